@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.12.3
+// source: todo-service.proto
 
 package v1
 
@@ -11,6 +15,7 @@ import (
 
 // This is a compile-time assertion to ensure that this generated file
 // is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
 const _ = grpc.SupportPackageIsVersion7
 
 // ToDoServiceClient is the client API for ToDoService service.
@@ -22,6 +27,13 @@ type ToDoServiceClient interface {
 	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
 	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
 	ReadAll(ctx context.Context, in *ReadAllRequest, opts ...grpc.CallOption) (*ReadAllResponse, error)
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// 附件的上传和下载是流式的，HTTP的multipart上传和Range下载由server的mux单独提供
+	UploadAttachment(ctx context.Context, opts ...grpc.CallOption) (ToDoService_UploadAttachmentClient, error)
+	DownloadAttachment(ctx context.Context, in *DownloadAttachmentRequest, opts ...grpc.CallOption) (ToDoService_DownloadAttachmentClient, error)
+	// 导入导出是流式的，HTTP的文件下载和multipart上传由server的mux单独提供
+	Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (ToDoService_ExportClient, error)
+	Import(ctx context.Context, opts ...grpc.CallOption) (ToDoService_ImportClient, error)
 }
 
 type toDoServiceClient struct {
@@ -77,6 +89,147 @@ func (c *toDoServiceClient) ReadAll(ctx context.Context, in *ReadAllRequest, opt
 	return out, nil
 }
 
+func (c *toDoServiceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, "/v1.ToDoService/Search", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *toDoServiceClient) UploadAttachment(ctx context.Context, opts ...grpc.CallOption) (ToDoService_UploadAttachmentClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ToDoService_ServiceDesc.Streams[0], "/v1.ToDoService/UploadAttachment", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &toDoServiceUploadAttachmentClient{stream}
+	return x, nil
+}
+
+type ToDoService_UploadAttachmentClient interface {
+	Send(*UploadAttachmentRequest) error
+	CloseAndRecv() (*UploadAttachmentResponse, error)
+	grpc.ClientStream
+}
+
+type toDoServiceUploadAttachmentClient struct {
+	grpc.ClientStream
+}
+
+func (x *toDoServiceUploadAttachmentClient) Send(m *UploadAttachmentRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *toDoServiceUploadAttachmentClient) CloseAndRecv() (*UploadAttachmentResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadAttachmentResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *toDoServiceClient) DownloadAttachment(ctx context.Context, in *DownloadAttachmentRequest, opts ...grpc.CallOption) (ToDoService_DownloadAttachmentClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ToDoService_ServiceDesc.Streams[1], "/v1.ToDoService/DownloadAttachment", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &toDoServiceDownloadAttachmentClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ToDoService_DownloadAttachmentClient interface {
+	Recv() (*DownloadAttachmentResponse, error)
+	grpc.ClientStream
+}
+
+type toDoServiceDownloadAttachmentClient struct {
+	grpc.ClientStream
+}
+
+func (x *toDoServiceDownloadAttachmentClient) Recv() (*DownloadAttachmentResponse, error) {
+	m := new(DownloadAttachmentResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *toDoServiceClient) Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (ToDoService_ExportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ToDoService_ServiceDesc.Streams[2], "/v1.ToDoService/Export", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &toDoServiceExportClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ToDoService_ExportClient interface {
+	Recv() (*ExportResponse, error)
+	grpc.ClientStream
+}
+
+type toDoServiceExportClient struct {
+	grpc.ClientStream
+}
+
+func (x *toDoServiceExportClient) Recv() (*ExportResponse, error) {
+	m := new(ExportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *toDoServiceClient) Import(ctx context.Context, opts ...grpc.CallOption) (ToDoService_ImportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ToDoService_ServiceDesc.Streams[3], "/v1.ToDoService/Import", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &toDoServiceImportClient{stream}
+	return x, nil
+}
+
+type ToDoService_ImportClient interface {
+	Send(*ImportRequest) error
+	CloseAndRecv() (*ImportResponse, error)
+	grpc.ClientStream
+}
+
+type toDoServiceImportClient struct {
+	grpc.ClientStream
+}
+
+func (x *toDoServiceImportClient) Send(m *ImportRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *toDoServiceImportClient) CloseAndRecv() (*ImportResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // ToDoServiceServer is the server API for ToDoService service.
 // All implementations must embed UnimplementedToDoServiceServer
 // for forward compatibility
@@ -86,6 +239,13 @@ type ToDoServiceServer interface {
 	Update(context.Context, *UpdateRequest) (*UpdateResponse, error)
 	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
 	ReadAll(context.Context, *ReadAllRequest) (*ReadAllResponse, error)
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// 附件的上传和下载是流式的，HTTP的multipart上传和Range下载由server的mux单独提供
+	UploadAttachment(ToDoService_UploadAttachmentServer) error
+	DownloadAttachment(*DownloadAttachmentRequest, ToDoService_DownloadAttachmentServer) error
+	// 导入导出是流式的，HTTP的文件下载和multipart上传由server的mux单独提供
+	Export(*ExportRequest, ToDoService_ExportServer) error
+	Import(ToDoService_ImportServer) error
 	mustEmbedUnimplementedToDoServiceServer()
 }
 
@@ -108,6 +268,21 @@ func (UnimplementedToDoServiceServer) Delete(context.Context, *DeleteRequest) (*
 func (UnimplementedToDoServiceServer) ReadAll(context.Context, *ReadAllRequest) (*ReadAllResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReadAll not implemented")
 }
+func (UnimplementedToDoServiceServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedToDoServiceServer) UploadAttachment(ToDoService_UploadAttachmentServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadAttachment not implemented")
+}
+func (UnimplementedToDoServiceServer) DownloadAttachment(*DownloadAttachmentRequest, ToDoService_DownloadAttachmentServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadAttachment not implemented")
+}
+func (UnimplementedToDoServiceServer) Export(*ExportRequest, ToDoService_ExportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Export not implemented")
+}
+func (UnimplementedToDoServiceServer) Import(ToDoService_ImportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Import not implemented")
+}
 func (UnimplementedToDoServiceServer) mustEmbedUnimplementedToDoServiceServer() {}
 
 // UnsafeToDoServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -117,8 +292,8 @@ type UnsafeToDoServiceServer interface {
 	mustEmbedUnimplementedToDoServiceServer()
 }
 
-func RegisterToDoServiceServer(s *grpc.Server, srv ToDoServiceServer) {
-	s.RegisterService(&_ToDoService_serviceDesc, srv)
+func RegisterToDoServiceServer(s grpc.ServiceRegistrar, srv ToDoServiceServer) {
+	s.RegisterService(&ToDoService_ServiceDesc, srv)
 }
 
 func _ToDoService_Create_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
@@ -211,7 +386,122 @@ func _ToDoService_ReadAll_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
-var _ToDoService_serviceDesc = grpc.ServiceDesc{
+func _ToDoService_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ToDoServiceServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/v1.ToDoService/Search",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ToDoServiceServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ToDoService_UploadAttachment_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ToDoServiceServer).UploadAttachment(&toDoServiceUploadAttachmentServer{stream})
+}
+
+type ToDoService_UploadAttachmentServer interface {
+	SendAndClose(*UploadAttachmentResponse) error
+	Recv() (*UploadAttachmentRequest, error)
+	grpc.ServerStream
+}
+
+type toDoServiceUploadAttachmentServer struct {
+	grpc.ServerStream
+}
+
+func (x *toDoServiceUploadAttachmentServer) SendAndClose(m *UploadAttachmentResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *toDoServiceUploadAttachmentServer) Recv() (*UploadAttachmentRequest, error) {
+	m := new(UploadAttachmentRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ToDoService_DownloadAttachment_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadAttachmentRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ToDoServiceServer).DownloadAttachment(m, &toDoServiceDownloadAttachmentServer{stream})
+}
+
+type ToDoService_DownloadAttachmentServer interface {
+	Send(*DownloadAttachmentResponse) error
+	grpc.ServerStream
+}
+
+type toDoServiceDownloadAttachmentServer struct {
+	grpc.ServerStream
+}
+
+func (x *toDoServiceDownloadAttachmentServer) Send(m *DownloadAttachmentResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ToDoService_Export_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ToDoServiceServer).Export(m, &toDoServiceExportServer{stream})
+}
+
+type ToDoService_ExportServer interface {
+	Send(*ExportResponse) error
+	grpc.ServerStream
+}
+
+type toDoServiceExportServer struct {
+	grpc.ServerStream
+}
+
+func (x *toDoServiceExportServer) Send(m *ExportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ToDoService_Import_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ToDoServiceServer).Import(&toDoServiceImportServer{stream})
+}
+
+type ToDoService_ImportServer interface {
+	SendAndClose(*ImportResponse) error
+	Recv() (*ImportRequest, error)
+	grpc.ServerStream
+}
+
+type toDoServiceImportServer struct {
+	grpc.ServerStream
+}
+
+func (x *toDoServiceImportServer) SendAndClose(m *ImportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *toDoServiceImportServer) Recv() (*ImportRequest, error) {
+	m := new(ImportRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ToDoService_ServiceDesc is the grpc.ServiceDesc for ToDoService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ToDoService_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "v1.ToDoService",
 	HandlerType: (*ToDoServiceServer)(nil),
 	Methods: []grpc.MethodDesc{
@@ -235,7 +525,32 @@ var _ToDoService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "ReadAll",
 			Handler:    _ToDoService_ReadAll_Handler,
 		},
+		{
+			MethodName: "Search",
+			Handler:    _ToDoService_Search_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadAttachment",
+			Handler:       _ToDoService_UploadAttachment_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DownloadAttachment",
+			Handler:       _ToDoService_DownloadAttachment_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Export",
+			Handler:       _ToDoService_Export_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Import",
+			Handler:       _ToDoService_Import_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "todo-service.proto",
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"go-grpc/internal/pkg/audit"
+)
+
+// 检查审计日志的哈希链，和server一样在cmd/auditverify目录下执行
+// 检查通过时输出记录数和最后一条记录的哈希，可以和之前保存的哈希比较，发现末尾的记录被截断
+func main() {
+	file := flag.String("file", "../../data/audit.log", "audit log file")
+	key := flag.String("key", "", "HMAC key configured as audit.key, empty if the log uses plain SHA-256")
+	flag.Parse()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatal("打开审计日志失败：", err)
+	}
+	defer f.Close()
+	res, err := audit.Verify(f, []byte(*key))
+	if err != nil {
+		log.Fatalf("审计日志校验失败，前%d条记录正常：%v", res.Entries, err)
+	}
+	if res.Last == nil {
+		log.Printf("%s中没有记录", *file)
+		return
+	}
+	log.Printf("审计日志校验通过，共%d条记录，最后一条seq=%d time=%s hash=%s", res.Entries, res.Last.Seq, res.Last.Time, res.Last.Hash)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-grpc/internal/pkg/certs"
+)
+
+// 生成本地开发用的CA、服务端证书和客户端证书，和server一样在cmd/certgen目录下执行
+// 已有的CA会继续使用，-force时全部重新生成
+func main() {
+	dir := flag.String("dir", "../../certs", "output directory")
+	commonName := flag.String("cn", "go-grpc.test.com", "server certificate common name")
+	hosts := flag.String("hosts", "localhost,127.0.0.1,::1,go-grpc.test.com", "comma separated DNS names and IPs for the server certificate")
+	client := flag.String("client", "go-grpc-client", "client certificate common name, empty to skip")
+	units := flag.String("client-ou", "dev", "comma separated client certificate OUs, used as roles")
+	days := flag.Int("days", 365, "validity of the server and client certificates in days")
+	force := flag.Bool("force", false, "regenerate everything including the CA")
+	flag.Parse()
+
+	generated, err := certs.EnsureDev(certs.DevOptions{
+		Dir:         *dir,
+		CommonName:  *commonName,
+		Hosts:       splitList(*hosts),
+		ClientName:  *client,
+		ClientUnits: splitList(*units),
+		ValidFor:    time.Duration(*days) * 24 * time.Hour,
+		Force:       *force,
+	})
+	if err != nil {
+		log.Fatal("生成证书失败：", err)
+	}
+	if !generated {
+		log.Printf("%s中的证书都可以继续使用，需要重新生成时加上-force", *dir)
+		return
+	}
+	log.Printf("证书已生成到%s，客户端需要信任%s", *dir, filepath.Join(*dir, certs.CAFile))
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -2,26 +2,36 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
-	v1 "go-grpc/api/server/v1"
+	"fmt"
 	"github.com/golang/protobuf/ptypes"
+	v1 "go-grpc/api/server/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"log"
-	"fmt"
 	"time"
-	"io/ioutil"
-	"gopkg.in/yaml.v2"
 )
 
 const (
-	apiVersion="v1"
+	apiVersion = "v1"
 )
 
 type config struct {
 	Server struct {
 		Host string `yaml:"host"`
 	}
+	TLS struct {
+		// 验证服务端证书的CA和证书中的名字
+		CAPemPath  string `yaml:"caPemPath"`
+		ServerName string `yaml:"serverName"`
+		// 服务端开启了mTLS时出示的客户端证书，为空时不出示
+		CertPemPath string `yaml:"certPemPath"`
+		CertKeyPath string `yaml:"certKeyPath"`
+	} `yaml:"tls"`
 }
 
 var cfg config
@@ -39,8 +49,10 @@ func init() {
 
 func main() {
 	address := flag.String("server", cfg.Server.Host, "gRPC server in format host:port")
+	certPemPath := flag.String("cert", cfg.TLS.CertPemPath, "client certificate for mTLS")
+	certKeyPath := flag.String("key", cfg.TLS.CertKeyPath, "client certificate key for mTLS")
 	flag.Parse()
-	creds, err := credentials.NewClientTLSFromFile("../../certs/server.pem", "go-grpc.test.com")
+	creds, err := clientCredentials(*certPemPath, *certKeyPath)
 	if err != nil {
 		log.Fatal("读取TLS文件失败啊", err)
 	}
@@ -60,12 +72,12 @@ func main() {
 	reminder, _ := ptypes.TimestampProto(t)
 	pfx := t.Format(time.RFC3339Nano)
 
-	req1 := v1.CreateRequest {
+	req1 := v1.CreateRequest{
 		Api: apiVersion,
-		ToDo:&v1.ToDo {
-			Title: "title (" + pfx + ")",
+		ToDo: &v1.ToDo{
+			Title:       "title (" + pfx + ")",
 			Description: "description (" + pfx + ")",
-			Reminder: reminder,
+			Reminder:    reminder,
 		},
 	}
 	// 调用RPC方法
@@ -76,20 +88,20 @@ func main() {
 	log.Printf("Create result: %v\n", res1)
 	id := res1.Id
 
-	req2 := v1.ReadRequest {Api: apiVersion, Id: id}
+	req2 := v1.ReadRequest{Api: apiVersion, Id: id}
 	res2, err := c.Read(ctx, &req2)
 	if err != nil {
 		log.Fatal("Read failed", err)
 	}
 	log.Printf("Read result %v\n", res2)
 
-	req3 := v1.UpdateRequest {
+	req3 := v1.UpdateRequest{
 		Api: apiVersion,
-		ToDo: &v1.ToDo {
-			Id: res2.ToDo.Id,
-			Title: res2.ToDo.Title + "changed",
+		ToDo: &v1.ToDo{
+			Id:          res2.ToDo.Id,
+			Title:       res2.ToDo.Title + "changed",
 			Description: res2.ToDo.Description,
-			Reminder: res2.ToDo.Reminder,
+			Reminder:    res2.ToDo.Reminder,
 		},
 	}
 	res3, err := c.Update(ctx, &req3)
@@ -98,7 +110,7 @@ func main() {
 	}
 	log.Printf("update result %v\n", res3)
 
-	req4 := v1.ReadAllRequest {
+	req4 := v1.ReadAllRequest{
 		Api: apiVersion,
 	}
 
@@ -108,13 +120,34 @@ func main() {
 	}
 	log.Printf("ReadAll result %v\n", res4)
 
-	req5 := v1.DeleteRequest {
+	req5 := v1.DeleteRequest{
 		Api: apiVersion,
-		Id: id,
+		Id:  id,
 	}
 	res5, err := c.Delete(ctx, &req5)
 	if err != nil {
 		log.Fatal("删除失败", err)
 	}
 	fmt.Printf("delete result %v\n", res5)
-}
\ No newline at end of file
+}
+
+// 用配置的CA验证服务端，配置了客户端证书时一起出示
+func clientCredentials(certPemPath, certKeyPath string) (credentials.TransportCredentials, error) {
+	ca, err := ioutil.ReadFile(cfg.TLS.CAPemPath)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("%s中没有有效的证书", cfg.TLS.CAPemPath)
+	}
+	tlsConfig := &tls.Config{RootCAs: roots, ServerName: cfg.TLS.ServerName}
+	if len(certPemPath) > 0 {
+		pair, err := tls.LoadX509KeyPair(certPemPath, certKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	server "go-grpc/internal/pkg/server"
+)
+
+// 用当前的主密钥重新加密所有的description，和server一样在cmd/reencrypt目录下执行，读取同一份配置
+func main() {
+	if err := server.RunReencrypt(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
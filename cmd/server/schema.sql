@@ -2,8 +2,39 @@ use grpc;
 CREATE TABLE `ToDo` (
     `ID` bigint(20) NOT NULL AUTO_INCREMENT,
     `Title` varchar(200) DEFAULT NULL,
-    `Description` varchar(1024) DEFAULT NULL,
+    -- 开启加密时保存的是密文，比明文长；已有的表执行 ALTER TABLE ToDo MODIFY `Description` TEXT DEFAULT NULL;
+    `Description` TEXT DEFAULT NULL,
     `Reminder` timestamp NULL DEFAULT NULL,
+    -- 创建者，认证后的Subject；已有的表执行 ALTER TABLE ToDo ADD `Owner` varchar(255) NOT NULL DEFAULT '', ADD KEY `Owner_IDX` (`Owner`);
+    -- 之前创建的ToDo没有所属用户，不会出现在任何人的日历订阅中
+    `Owner` varchar(255) NOT NULL DEFAULT '',
     PRIMARY KEY (`ID`),
-    UNIQUE KEY `ID_UNIQUE` (`ID`)
-);
\ No newline at end of file
+    UNIQUE KEY `ID_UNIQUE` (`ID`),
+    KEY `Owner_IDX` (`Owner`),
+    FULLTEXT KEY `Title_Description_FT` (`Title`, `Description`) WITH PARSER ngram
+);
+
+CREATE TABLE `Attachment` (
+    `ID` bigint(20) NOT NULL AUTO_INCREMENT,
+    `ToDoID` bigint(20) NOT NULL,
+    `Name` varchar(255) NOT NULL,
+    `ContentType` varchar(255) DEFAULT NULL,
+    `Size` bigint(20) NOT NULL DEFAULT 0,
+    `BlobKey` varchar(512) NOT NULL,
+    `CreatedAt` timestamp NULL DEFAULT NULL,
+    PRIMARY KEY (`ID`),
+    KEY `ToDoID_IDX` (`ToDoID`)
+);
+
+CREATE TABLE `APIKey` (
+    `ID` bigint(20) NOT NULL AUTO_INCREMENT,
+    `Name` varchar(200) NOT NULL,
+    `Prefix` varchar(16) NOT NULL,
+    `Hash` char(64) NOT NULL,
+    `Scopes` varchar(255) NOT NULL DEFAULT '',
+    `CreatedAt` timestamp NULL DEFAULT NULL,
+    `RotatedAt` timestamp NULL DEFAULT NULL,
+    `RevokedAt` timestamp NULL DEFAULT NULL,
+    PRIMARY KEY (`ID`),
+    UNIQUE KEY `Prefix_UNIQUE` (`Prefix`)
+);
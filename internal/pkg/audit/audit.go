@@ -0,0 +1,346 @@
+// audit 记录修改数据的调用：谁、什么时候、调用了什么方法、改了哪个资源，以及修改前后内容的哈希
+// 每条记录带上一条记录的哈希，串成一条哈希链写到只追加的文件中，中间任何一条被修改或者删除都能被Verify发现
+// 配置了密钥时用HMAC-SHA256代替SHA-256，没有密钥的人即使能改文件也不能重新计算出一条合法的链
+// 拦截器在请求开始时记下调用方，service在写入成功之后通过Record报告改了哪些资源，请求结束时一起写入
+package audit
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go-grpc/internal/pkg/auth"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// 修改的类型，由修改前后的哈希推断
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Entry 是审计日志中的一行，JSON格式，字段的顺序是固定的，哈希按这个顺序计算
+type Entry struct {
+	Seq  int64  `json:"seq"`
+	Time string `json:"time"`
+	// 调用方，没有认证时为空
+	Subject    string `json:"subject,omitempty"`
+	AuthMethod string `json:"authMethod,omitempty"`
+	// gRPC的FullMethod，直接处理的HTTP接口用对应的gRPC方法
+	Method string `json:"method"`
+	// 被修改的资源，比如todos/1；调用失败或者没有修改任何资源时为空
+	Resource string `json:"resource,omitempty"`
+	Action   string `json:"action,omitempty"`
+	// 修改前后内容的哈希，新建时没有before，删除时没有after
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	// 调用的结果，gRPC的状态码，比如OK、NotFound；直接处理的HTTP接口是HTTP的状态码
+	Code string `json:"code"`
+	// 上一条记录的哈希，第一条为空
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// 计算记录的哈希，不包括Hash本身
+func (e Entry) sum(key []byte) (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	var h hash.Hash
+	if len(key) > 0 {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Options 是审计日志的文件和需要记录的方法
+type Options struct {
+	Path string
+	// 计算HMAC的密钥，为空时使用SHA-256
+	Key []byte
+	// 需要记录的gRPC方法，支持path.Match的通配符
+	Methods []string
+}
+
+// Log 是只追加的审计日志文件，多个请求同时写入时按写入的顺序串成一条链
+type Log struct {
+	opts Options
+
+	mu   sync.Mutex
+	f    *os.File
+	seq  int64
+	last string
+}
+
+// Open 打开审计日志，接着文件中最后一条记录继续写
+// 最后一行不完整（比如写到一半时断电）时返回错误，需要先用Verify检查文件
+func Open(opts Options) (*Log, error) {
+	for _, m := range opts.Methods {
+		if _, err := path.Match(m, ""); err != nil {
+			return nil, fmt.Errorf("审计的方法%s格式错误：%v", m, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0755); err != nil {
+		return nil, err
+	}
+	last, err := lastEntry(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{opts: opts, f: f}
+	if last != nil {
+		l.seq, l.last = last.Seq, last.Hash
+	}
+	return l, nil
+}
+
+// 读取文件中的最后一条记录，文件不存在或者为空时返回nil
+func lastEntry(name string) (*Entry, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var line []byte
+	scanner := newScanner(f)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			line = append(line[:0], scanner.Bytes()...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == nil {
+		return nil, nil
+	}
+	var e Entry
+	if err := json.Unmarshal(line, &e); err != nil || len(e.Hash) == 0 {
+		return nil, fmt.Errorf("%s的最后一条记录不完整，请先检查审计日志", name)
+	}
+	return &e, nil
+}
+
+// Close 关闭文件
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// Audited 返回method是否需要记录
+func (l *Log) Audited(method string) bool {
+	for _, m := range l.opts.Methods {
+		if ok, _ := path.Match(m, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Append 把entries接在链的末尾，写入之后同步到磁盘
+// Seq、Prev和Hash由这里填写，Time为空时使用当前时间
+func (l *Log) Append(entries ...Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq, last := l.seq, l.last
+	var buf []byte
+	for _, e := range entries {
+		seq++
+		e.Seq, e.Prev = seq, last
+		if len(e.Time) == 0 {
+			e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+		}
+		sum, err := e.sum(l.opts.Key)
+		if err != nil {
+			return err
+		}
+		e.Hash = sum
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, data...), '\n')
+		last = sum
+	}
+	// 一次写入，不会和其他请求的记录交错
+	n, err := l.f.Write(buf)
+	if err != nil {
+		// 只写了一部分时把这部分截掉，否则下一条记录接在半行后面，整个文件都通不过Verify
+		if n > 0 {
+			if terr := l.truncate(int64(n)); terr != nil {
+				return fmt.Errorf("%v，截掉写了一半的记录失败：%v", err, terr)
+			}
+		}
+		return err
+	}
+	// 写入成功之后记录已经在文件中了，同步失败也要接着这些记录继续写，不然下一条会重复seq和prev
+	l.seq, l.last = seq, last
+	return l.f.Sync()
+}
+
+// 截掉文件末尾n个字节，文件是O_APPEND打开的，下一次写入会接在截断的位置
+func (l *Log) truncate(n int64) error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	return l.f.Truncate(info.Size() - n)
+}
+
+// Hash 返回消息内容的哈希，用作记录中修改前后的内容；m为nil时返回空字符串
+func Hash(m protov1.Message) string {
+	if m == nil {
+		return ""
+	}
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(protov1.MessageV2(m))
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type change struct {
+	resource, before, after string
+}
+
+// 一个请求中报告的修改
+type recorder struct {
+	mu      sync.Mutex
+	changes []change
+}
+
+type recorderKey struct{}
+
+// Record 报告当前请求修改了resource，before和after是Hash的结果；请求不需要审计时什么也不做
+// 在写入提交成功之后调用
+func Record(ctx context.Context, resource, before, after string) {
+	r, ok := ctx.Value(recorderKey{}).(*recorder)
+	if !ok {
+		return
+	}
+	r.mu.Lock()
+	r.changes = append(r.changes, change{resource: resource, before: before, after: after})
+	r.mu.Unlock()
+}
+
+// Begin 开始记录一次调用，返回的ctx传给handler，调用结束时用状态码调用返回的函数
+// method不需要审计时原样返回ctx
+func (l *Log) Begin(ctx context.Context, method string) (context.Context, func(code string)) {
+	if !l.Audited(method) {
+		return ctx, func(string) {}
+	}
+	base := Entry{Method: method}
+	if id, ok := auth.FromContext(ctx); ok {
+		base.Subject, base.AuthMethod = id.Subject, id.Method
+	}
+	r := &recorder{}
+	return context.WithValue(ctx, recorderKey{}, r), func(code string) {
+		base.Code = code
+		r.mu.Lock()
+		entries := make([]Entry, 0, len(r.changes))
+		for _, c := range r.changes {
+			e := base
+			e.Resource, e.Before, e.After, e.Action = c.resource, c.before, c.after, action(c)
+			entries = append(entries, e)
+		}
+		r.mu.Unlock()
+		// 失败的调用和没有修改任何资源的调用也记一条
+		if len(entries) == 0 {
+			entries = append(entries, base)
+		}
+		// 数据已经写入了，不能因为审计日志写失败而让请求失败
+		if err := l.Append(entries...); err != nil {
+			zap.L().Error("写入审计日志失败", zap.String("method", method), zap.Error(err))
+		}
+	}
+}
+
+func action(c change) string {
+	switch {
+	case len(c.before) == 0:
+		return ActionCreate
+	case len(c.after) == 0:
+		return ActionDelete
+	default:
+		return ActionUpdate
+	}
+}
+
+// finishOnPanic 在defer中调用，handler panic时按Internal记一条，然后继续panic，交给外层的recovery处理
+// panic之前已经提交的修改也会记下来
+func finishOnPanic(finish func(code string)) {
+	if r := recover(); r != nil {
+		finish(codes.Internal.String())
+		panic(r)
+	}
+}
+
+// UnaryServerInterceptor 记录需要审计的unary调用，要在auth里面才能拿到调用方
+func (l *Log) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, finish := l.Begin(ctx, info.FullMethod)
+		defer finishOnPanic(finish)
+		res, err := handler(ctx, req)
+		finish(status.Code(err).String())
+		return res, err
+	}
+}
+
+// StreamServerInterceptor 记录需要审计的流式调用，比如上传附件和导入
+func (l *Log) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, finish := l.Begin(ss.Context(), info.FullMethod)
+		defer finishOnPanic(finish)
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		finish(status.Code(err).String())
+		return err
+	}
+}
+
+// 带着记录修改的ctx
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+var testKey = []byte("audit-test-key")
+
+func openTestLog(t *testing.T, methods ...string) (*Log, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	name := filepath.Join(dir, "audit.log")
+	l, err := Open(Options{Path: name, Key: testKey, Methods: methods})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, name
+}
+
+func readLines(t *testing.T, name string) []string {
+	t.Helper()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestAppendChain(t *testing.T) {
+	l, name := openTestLog(t)
+	if err := l.Append(Entry{Method: "/v2.ToDoService/Create", Code: "OK"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(Entry{Method: "/v2.ToDoService/Update", Code: "OK"}, Entry{Method: "/v2.ToDoService/Update", Code: "OK"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// 重新打开之后接着最后一条记录继续写
+	l, err := Open(Options{Path: name, Key: testKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := l.Append(Entry{Method: "/v2.ToDoService/Delete", Code: "OK"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	res, err := Verify(f, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Entries != 4 || res.Last.Seq != 4 || res.Last.Method != "/v2.ToDoService/Delete" {
+		t.Fatalf("应该有4条记录，最后一条是Delete，结果是%d条，%+v", res.Entries, res.Last)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	l, name := openTestLog(t)
+	for _, code := range []string{"OK", "NotFound", "OK"} {
+		if err := l.Append(Entry{Method: "/v2.ToDoService/Update", Resource: "todos/1", Code: code}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := readLines(t, name)
+
+	tests := []struct {
+		name  string
+		lines []string
+		key   []byte
+		// 为空时检查通过，否则错误中要包含这个字符串
+		err string
+	}{
+		{"没有修改", lines, testKey, ""},
+		{"修改了一行的内容", []string{lines[0], strings.Replace(lines[1], "NotFound", "OK", 1), lines[2]}, testKey, "第2行"},
+		{"修改了资源", []string{lines[0], lines[1], strings.Replace(lines[2], "todos/1", "todos/2", 1)}, testKey, "第3行"},
+		{"删除了中间的一行", []string{lines[0], lines[2]}, testKey, "序号"},
+		{"交换了两行", []string{lines[1], lines[0], lines[2]}, testKey, "第1行"},
+		{"多了一个字段", []string{lines[0], strings.Replace(lines[1], `{"seq"`, `{"x":1,"seq"`, 1), lines[2]}, testKey, "第2行"},
+		{"最后一行不完整", []string{lines[0], lines[1], lines[2][:len(lines[2])/2]}, testKey, "第3行"},
+		{"密钥不对", lines, []byte("other-key"), "第1行"},
+		{"没有密钥", lines, nil, "第1行"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := strings.NewReader(strings.Join(tt.lines, "\n") + "\n")
+			_, err := Verify(r, tt.key)
+			if len(tt.err) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("错误应该包含%q，结果是%v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestOpenPartialLastLine(t *testing.T) {
+	l, name := openTestLog(t)
+	if err := l.Append(Entry{Method: "/v2.ToDoService/Create", Code: "OK"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":2,"time":`)
+	f.Close()
+	if _, err := Open(Options{Path: name, Key: testKey}); err == nil {
+		t.Fatal("最后一行不完整时应该返回错误")
+	}
+}
+
+func TestBeginRecord(t *testing.T) {
+	l, name := openTestLog(t, "/v2.ToDoService/*")
+	ctx, finish := l.Begin(context.Background(), "/v2.ToDoService/Delete")
+	Record(ctx, "attachments/3", "a", "")
+	Record(ctx, "todos/1", "b", "")
+	finish("OK")
+	ctx, finish = l.Begin(context.Background(), "/v2.ToDoService/Update")
+	Record(ctx, "todos/2", "b", "c")
+	finish("OK")
+	// 没有修改任何资源的调用也记一条
+	_, finish = l.Begin(context.Background(), "/v2.ToDoService/Create")
+	finish("InvalidArgument")
+	// 不需要审计的方法不记录
+	ctx, finish = l.Begin(context.Background(), "/v1.ToDoService/Create")
+	Record(ctx, "todos/3", "", "d")
+	finish("OK")
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Verify(bytes.NewReader(data), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Entries != 4 {
+		t.Fatalf("应该有4条记录，结果是%d条", res.Entries)
+	}
+	lines := readLines(t, name)
+	want := []string{
+		`"resource":"attachments/3","action":"delete"`,
+		`"resource":"todos/1","action":"delete"`,
+		`"resource":"todos/2","action":"update"`,
+		`"method":"/v2.ToDoService/Create","code":"InvalidArgument"`,
+	}
+	for i, w := range want {
+		if !strings.Contains(lines[i], w) {
+			t.Fatalf("第%d条记录应该包含%s，结果是%s", i+1, w, lines[i])
+		}
+	}
+}
+
+func TestInterceptorPanic(t *testing.T) {
+	l, name := openTestLog(t, "/v2.ToDoService/*")
+	unary := l.UnaryServerInterceptor()
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("panic应该继续向外传递，结果是%v", r)
+			}
+		}()
+		unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/v2.ToDoService/Delete"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			// panic之前已经提交的修改也要记下来
+			Record(ctx, "todos/1", "a", "")
+			panic("boom")
+		})
+	}()
+	stream := l.StreamServerInterceptor()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic应该继续向外传递")
+			}
+		}()
+		stream(nil, &fakeStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/v2.ToDoService/Import"}, func(srv interface{}, ss grpc.ServerStream) error {
+			panic("boom")
+		})
+	}()
+
+	lines := readLines(t, name)
+	want := []string{
+		`"method":"/v2.ToDoService/Delete","resource":"todos/1","action":"delete","before":"a","code":"Internal"`,
+		`"method":"/v2.ToDoService/Import","code":"Internal"`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("应该有%d条记录，结果是%d条", len(want), len(lines))
+	}
+	for i, w := range want {
+		if !strings.Contains(lines[i], w) {
+			t.Fatalf("第%d条记录应该包含%s，结果是%s", i+1, w, lines[i])
+		}
+	}
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
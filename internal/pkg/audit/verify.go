@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Result 是Verify检查通过的记录数和最后一条记录
+// 把Last的哈希另外保存起来（比如定期发到别的系统），可以发现末尾的记录被截断
+type Result struct {
+	Entries int64
+	Last    *Entry
+}
+
+// Verify 从头检查r中的哈希链：序号连续、prev等于上一条的哈希、哈希和内容一致
+// 遇到第一条不合法的记录时返回错误，错误中带着行号；key和写入时的一致
+func Verify(r io.Reader, key []byte) (*Result, error) {
+	res := &Result{}
+	scanner := newScanner(r)
+	var prev string
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return res, fmt.Errorf("第%d行不是有效的记录：%v", line, err)
+		}
+		if e.Seq != res.Entries+1 {
+			return res, fmt.Errorf("第%d行的序号是%d，应该是%d，中间的记录可能被删除了", line, e.Seq, res.Entries+1)
+		}
+		if e.Prev != prev {
+			return res, fmt.Errorf("第%d行（seq=%d）的prev和上一条记录的哈希不一致", line, e.Seq)
+		}
+		sum, err := e.sum(key)
+		if err != nil {
+			return res, err
+		}
+		if sum != e.Hash {
+			return res, fmt.Errorf("第%d行（seq=%d）的哈希和内容不一致，记录被修改过或者密钥不对", line, e.Seq)
+		}
+		// 多出来的字段不参与哈希，重新编码之后必须和原来的一行完全相同
+		canonical, err := json.Marshal(e)
+		if err != nil {
+			return res, err
+		}
+		if !bytes.Equal(canonical, data) {
+			return res, fmt.Errorf("第%d行（seq=%d）的格式被修改过", line, e.Seq)
+		}
+		res.Entries++
+		res.Last = &e
+		prev = e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
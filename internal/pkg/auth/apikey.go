@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"go-grpc/internal/pkg/errs"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// MethodAPIKey 是API key认证的Identity.Method
+	MethodAPIKey = "apikey"
+	// APIKeyHeader 是放API key的metadata，REST请求是X-Api-Key头
+	APIKeyHeader = "x-api-key"
+
+	// key的格式是gk_{prefix}_{secret}，prefix用来在数据库中查找，secret只保存hash
+	apiKeyMark  = "gk"
+	prefixBytes = 6
+	secretBytes = 32
+)
+
+// StoredAPIKey 是数据库中保存的key
+type StoredAPIKey struct {
+	ID     int64
+	Hash   string
+	Scopes []string
+}
+
+// APIKeyStore 按prefix查找没有吊销的key，找不到时返回nil, nil
+type APIKeyStore interface {
+	LookupAPIKey(ctx context.Context, prefix string) (*StoredAPIKey, error)
+}
+
+// NewAPIKey 生成一个新的key，返回完整的key、prefix和保存到数据库中的hash
+func NewAPIKey() (key, prefix, hash string, err error) {
+	p := make([]byte, prefixBytes)
+	s := make([]byte, secretBytes)
+	if _, err := rand.Read(p); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(s); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(p)
+	secret := base64.RawURLEncoding.EncodeToString(s)
+	return apiKeyMark + "_" + prefix + "_" + secret, prefix, HashAPIKeySecret(secret), nil
+}
+
+// HashAPIKeySecret 计算secret的hash，secret本身是随机生成的高熵字符串，不需要加盐和慢hash
+func HashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// 拆出key中的prefix和secret，secret的base64中也可能有_，所以只拆前两段
+func parseAPIKey(key string) (prefix, secret string, ok bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyMark || len(parts[1]) != prefixBytes*2 || len(parts[2]) == 0 {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// APIKeyAuthenticator 验证x-api-key中的key
+type APIKeyAuthenticator struct {
+	store APIKeyStore
+}
+
+func NewAPIKeyAuthenticator(store APIKeyStore) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{store: store}
+}
+
+func (a *APIKeyAuthenticator) Authenticate(ctx context.Context, md metadata.MD) (*Identity, error) {
+	values := md.Get(APIKeyHeader)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	invalid := errs.New(codes.Unauthenticated, errs.ReasonInvalidAPIKey, "API key无效或已吊销")
+	prefix, secret, ok := parseAPIKey(values[0])
+	if !ok {
+		return nil, invalid
+	}
+	stored, err := a.store.LookupAPIKey(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || subtle.ConstantTimeCompare([]byte(stored.Hash), []byte(HashAPIKeySecret(secret))) != 1 {
+		return nil, invalid
+	}
+	return &Identity{
+		Subject: "apikey:" + strconv.FormatInt(stored.ID, 10),
+		Method:  MethodAPIKey,
+		Scopes:  stored.Scopes,
+	}, nil
+}
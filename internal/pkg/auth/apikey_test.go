@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		prefix string
+		secret string
+		ok     bool
+	}{
+		{"正常的key", "gk_0123456789ab_secret", "0123456789ab", "secret", true},
+		{"secret中有下划线", "gk_0123456789ab_se_cr_et", "0123456789ab", "se_cr_et", true},
+		{"标记不对", "xk_0123456789ab_secret", "", "", false},
+		{"prefix太短", "gk_0123456789a_secret", "", "", false},
+		{"prefix太长", "gk_0123456789abc_secret", "", "", false},
+		{"没有secret", "gk_0123456789ab_", "", "", false},
+		{"只有两段", "gk_0123456789ab", "", "", false},
+		{"空字符串", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, secret, ok := parseAPIKey(tt.key)
+			if ok != tt.ok || prefix != tt.prefix || secret != tt.secret {
+				t.Fatalf("parseAPIKey(%q) = %q, %q, %v，应该是%q, %q, %v", tt.key, prefix, secret, ok, tt.prefix, tt.secret, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewAPIKey(t *testing.T) {
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, secret, ok := parseAPIKey(key)
+	if !ok || p != prefix {
+		t.Fatalf("生成的key %q 解析不出prefix %q", key, prefix)
+	}
+	if hash != HashAPIKeySecret(secret) {
+		t.Fatal("hash和secret不匹配")
+	}
+	if strings.Contains(hash, secret) {
+		t.Fatal("hash中不能包含secret")
+	}
+	other, _, _, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key {
+		t.Fatal("两次生成的key相同")
+	}
+}
+
+type fakeAPIKeyStore map[string]*StoredAPIKey
+
+func (s fakeAPIKeyStore) LookupAPIKey(ctx context.Context, prefix string) (*StoredAPIKey, error) {
+	if prefix == "ffffffffffff" {
+		return nil, errors.New("数据库不可用")
+	}
+	return s[prefix], nil
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAPIKeyAuthenticator(fakeAPIKeyStore{
+		prefix: {ID: 7, Hash: hash, Scopes: []string{"todos.read"}},
+	})
+	tests := []struct {
+		name string
+		md   metadata.MD
+		ok   bool
+		// 错误的状态码，没有错误时是OK
+		code codes.Code
+	}{
+		{"没有key时交给其他认证方式", metadata.MD{}, false, codes.OK},
+		{"正确的key", metadata.Pairs(APIKeyHeader, key), true, codes.OK},
+		{"secret错误", metadata.Pairs(APIKeyHeader, "gk_"+prefix+"_wrong-secret"), false, codes.Unauthenticated},
+		{"prefix不存在", metadata.Pairs(APIKeyHeader, "gk_000000000000"+key[len("gk_")+prefixBytes*2:]), false, codes.Unauthenticated},
+		{"格式错误", metadata.Pairs(APIKeyHeader, "not-a-key"), false, codes.Unauthenticated},
+		{"查询失败", metadata.Pairs(APIKeyHeader, "gk_ffffffffffff_secret"), false, codes.Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := a.Authenticate(context.Background(), tt.md)
+			if (id != nil) != tt.ok || status.Code(err) != tt.code {
+				t.Fatalf("应该返回%v, %v，结果是%+v, %v", tt.ok, tt.code, id, err)
+			}
+			if tt.ok && (id.Subject != "apikey:7" || id.Method != MethodAPIKey || len(id.Scopes) != 1 || id.Scopes[0] != "todos.read") {
+				t.Fatalf("Identity不对：%+v", id)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+// auth 识别调用方的身份：从gRPC的metadata（gateway会把HTTP的Authorization头转发过来）中取出凭证，
+// 交给配置的Authenticator依次验证，验证通过后把Identity放到context中，handler通过FromContext取得调用方
+package auth
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"go-grpc/internal/pkg/errs"
+	"go-grpc/internal/pkg/logging"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// 接口的权限，API key和带scope的JWT按它们授权
+const (
+	ScopeToDoRead    = "todo.read"
+	ScopeToDoWrite   = "todo.write"
+	ScopeAPIKeyAdmin = "apikey.admin"
+)
+
+// Scopes 是所有可以授予的scope
+var Scopes = []string{ScopeToDoRead, ScopeToDoWrite, ScopeAPIKeyAdmin}
+
+// Identity 是认证后的调用方
+type Identity struct {
+	// 用户或者服务的标识，JWT中的sub
+	Subject string
+	// 签发方，JWT中的iss
+	Issuer string
+	// 认证方式，比如jwt、apikey、mtls
+	Method string
+	Roles  []string
+	Scopes []string
+}
+
+// HasScope 判断调用方是否有scope
+func (id *Identity) HasScope(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+// NewContext 返回带有调用方身份的context
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext 返回当前请求的调用方，没有开启认证或者是公开的方法时返回false
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(*Identity)
+	return id, ok
+}
+
+// Authenticator 从请求的metadata中识别调用方
+// 请求中没有它支持的凭证时返回nil, nil，交给下一个Authenticator；凭证无效时返回错误
+type Authenticator interface {
+	Authenticate(ctx context.Context, md metadata.MD) (*Identity, error)
+}
+
+// Guard 要求每个请求都通过认证，public中的方法（按前缀匹配）除外
+// scopes是方法需要的scope，key是FullMethod，没有列出的方法只要求通过认证
+type Guard struct {
+	authenticators []Authenticator
+	public         []string
+	scopes         map[string]string
+}
+
+// NewGuard 创建一个Guard，authenticators按顺序尝试
+func NewGuard(public []string, scopes map[string]string, authenticators ...Authenticator) *Guard {
+	return &Guard{authenticators: authenticators, public: public, scopes: scopes}
+}
+
+func (g *Guard) isPublic(method string) bool {
+	for _, p := range g.public {
+		if strings.HasPrefix(method, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate 识别调用方，返回带有身份的context
+func (g *Guard) Authenticate(ctx context.Context, md metadata.MD) (context.Context, error) {
+	for _, a := range g.authenticators {
+		id, err := a.Authenticate(ctx, md)
+		if err != nil {
+			return nil, err
+		}
+		if id != nil {
+			logging.AddFields(ctx, zap.String("subject", id.Subject), zap.String("auth", id.Method))
+			return NewContext(ctx, id), nil
+		}
+	}
+	return nil, errs.New(codes.Unauthenticated, errs.ReasonUnauthenticated, "缺少认证信息")
+}
+
+// Authorize 检查当前调用方有没有scope
+func (g *Guard) Authorize(ctx context.Context, scope string) error {
+	id, ok := FromContext(ctx)
+	if !ok {
+		return errs.New(codes.Unauthenticated, errs.ReasonUnauthenticated, "缺少认证信息")
+	}
+	if !id.HasScope(scope) {
+		return errs.New(codes.PermissionDenied, errs.ReasonInsufficientScope, "没有"+scope+"权限", "scope", scope)
+	}
+	return nil
+}
+
+// AuthorizeMethod 检查当前调用方有没有method需要的scope
+func (g *Guard) AuthorizeMethod(ctx context.Context, method string) error {
+	scope, ok := g.scopes[method]
+	if !ok {
+		return nil
+	}
+	return g.Authorize(ctx, scope)
+}
+
+// AuthenticateHTTP 识别直接处理HTTP请求（不经过gateway）的调用方，请求头按gRPC metadata的规则转成小写
+// TLS连接的信息和gRPC一样放在peer中，客户端证书的认证对两边都适用
+func (g *Guard) AuthenticateHTTP(r *http.Request) (context.Context, error) {
+	md := make(metadata.MD, len(r.Header))
+	for k, v := range r.Header {
+		md[strings.ToLower(k)] = v
+	}
+	// 转发的证书只有gateway的gRPC连接才能带，直接的HTTP请求中不能出现
+	delete(md, ForwardedCertHeader)
+	ctx := r.Context()
+	if r.TLS != nil {
+		p := &peer.Peer{AuthInfo: credentials.TLSInfo{State: *r.TLS}}
+		if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+			p.Addr = addr
+		}
+		ctx = peer.NewContext(ctx, p)
+	}
+	return g.Authenticate(ctx, md)
+}
+
+// UnaryServerInterceptor 在调用handler之前认证
+func (g *Guard) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if g.isPublic(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx, err := g.Authenticate(ctx, md)
+		if err != nil {
+			return nil, err
+		}
+		if err := g.AuthorizeMethod(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// 把认证后的context带给流式的handler
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor 在建立流的时候认证一次
+func (g *Guard) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if g.isPublic(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		ctx, err := g.Authenticate(ss.Context(), md)
+		if err != nil {
+			return err
+		}
+		if err := g.AuthorizeMethod(ctx, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+)
+
+func rsaJWK(t *testing.T, kid string, key *rsa.PrivateKey) jwk {
+	t.Helper()
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func writeJWKS(t *testing.T, keys ...jwk) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	b, err := json.Marshal(map[string][]jwk{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func signRS256(t *testing.T, kid string, key *rsa.PrivateKey) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub": "golearner",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	})
+	if len(kid) > 0 {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWKSKeySelection(t *testing.T) {
+	keys := make([]*rsa.PrivateKey, 4)
+	for i := range keys {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+	// 两个没有kid的公钥都要保留，不能只剩最后一个
+	path := writeJWKS(t, rsaJWK(t, "", keys[0]), rsaJWK(t, "", keys[1]), rsaJWK(t, "k2", keys[2]))
+	a, err := NewJWTAuthenticator(JWTOptions{JWKSFiles: []string{path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		kid  string
+		key  *rsa.PrivateKey
+		ok   bool
+	}{
+		{"第一个没有kid的公钥", "", keys[0], true},
+		{"第二个没有kid的公钥", "", keys[1], true},
+		{"按kid选择", "k2", keys[2], true},
+		{"没有kid时也试有kid的公钥", "", keys[2], true},
+		{"未知的kid尝试没有kid的公钥", "rotated", keys[0], true},
+		{"kid和公钥不匹配", "k2", keys[0], false},
+		{"没有配置的公钥", "", keys[3], false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.Pairs("authorization", "Bearer "+signRS256(t, tt.kid, tt.key))
+			id, err := a.Authenticate(context.Background(), md)
+			if tt.ok && (err != nil || id == nil || id.Subject != "golearner") {
+				t.Fatalf("应该验证通过，结果是%v, %v", id, err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("应该验证失败")
+			}
+		})
+	}
+}
+
+func TestLoadJWKSDuplicateKid(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeJWKS(t, rsaJWK(t, "k1", key), rsaJWK(t, "k1", key))
+	if _, _, err := loadJWKS(path); err == nil {
+		t.Fatal("kid重复时应该返回错误")
+	}
+	other := writeJWKS(t, rsaJWK(t, "k1", key))
+	single := writeJWKS(t, rsaJWK(t, "k1", key))
+	if _, err := NewJWTAuthenticator(JWTOptions{JWKSFiles: []string{single, other}}); err == nil {
+		t.Fatal("不同文件中kid重复时应该返回错误")
+	}
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go-grpc/internal/pkg/errs"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// MethodJWT 是JWT认证的Identity.Method
+const MethodJWT = "jwt"
+
+// JWTOptions 是JWT的验证规则
+type JWTOptions struct {
+	// 为空时不校验iss和aud
+	Issuer   string
+	Audience string
+	// 非对称签名（RS、PS、ES）的公钥
+	JWKSFiles []string
+	// 对称签名（HS）的密钥，可以配置多个用于轮换
+	HMACSecrets []string
+	// 允许的时钟偏差
+	Leeway time.Duration
+	// 角色所在的claim，默认roles
+	RolesClaim string
+	// token中没有scope时授予的scope，一般是用户登录后拿到的token
+	DefaultScopes []string
+}
+
+// JWTAuthenticator 验证authorization: Bearer中的JWT
+type JWTAuthenticator struct {
+	keys          map[string]interface{}
+	secrets       []jwt.VerificationKey
+	parser        *jwt.Parser
+	rolesClaim    string
+	defaultScopes []string
+	// 没有kid的公钥，按kid找不到公钥时逐个尝试
+	unkeyed []interface{}
+}
+
+// NewJWTAuthenticator 读取JWKS文件，创建JWTAuthenticator
+func NewJWTAuthenticator(opts JWTOptions) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{keys: make(map[string]interface{}), rolesClaim: opts.RolesClaim, defaultScopes: opts.DefaultScopes}
+	if len(a.rolesClaim) == 0 {
+		a.rolesClaim = "roles"
+	}
+	var methods []string
+	for _, path := range opts.JWKSFiles {
+		keys, unkeyed, err := loadJWKS(path)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range keys {
+			if _, ok := a.keys[kid]; ok {
+				return nil, fmt.Errorf("JWKS文件%s中的kid %s和其他文件重复", path, kid)
+			}
+			a.keys[kid] = key
+		}
+		a.unkeyed = append(a.unkeyed, unkeyed...)
+	}
+	if len(a.keys) > 0 || len(a.unkeyed) > 0 {
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+	for _, s := range opts.HMACSecrets {
+		a.secrets = append(a.secrets, []byte(s))
+	}
+	if len(a.secrets) > 0 {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("没有配置JWKS文件或者HMAC密钥")
+	}
+	// 只接受配置了密钥的算法，避免alg=none或者用公钥当HMAC密钥的攻击
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(opts.Leeway),
+	}
+	if len(opts.Issuer) > 0 {
+		parserOpts = append(parserOpts, jwt.WithIssuer(opts.Issuer))
+	}
+	if len(opts.Audience) > 0 {
+		parserOpts = append(parserOpts, jwt.WithAudience(opts.Audience))
+	}
+	a.parser = jwt.NewParser(parserOpts...)
+	return a, nil
+}
+
+// 按kid选择公钥；kid找不到时尝试没有kid的公钥，token没有kid时所有公钥都试一遍
+func (a *JWTAuthenticator) keyFunc(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		return jwt.VerificationKeySet{Keys: a.secrets}, nil
+	}
+	set := jwt.VerificationKeySet{}
+	if kid, ok := t.Header["kid"].(string); ok && len(kid) > 0 {
+		if key, ok := a.keys[kid]; ok {
+			return key, nil
+		}
+		if len(a.unkeyed) == 0 {
+			return nil, fmt.Errorf("未知的kid：%s", kid)
+		}
+	} else {
+		for _, key := range a.keys {
+			set.Keys = append(set.Keys, key)
+		}
+	}
+	for _, key := range a.unkeyed {
+		set.Keys = append(set.Keys, key)
+	}
+	return set, nil
+}
+
+// bearerToken 取出authorization: Bearer后面的token
+func bearerToken(md metadata.MD) (string, bool) {
+	for _, v := range md.Get("authorization") {
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			return strings.TrimSpace(v[7:]), true
+		}
+	}
+	return "", false
+}
+
+// Authenticate 验证签名、iss、aud和过期时间，通过后从claims中取出调用方
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, md metadata.MD) (*Identity, error) {
+	token, ok := bearerToken(md)
+	if !ok {
+		return nil, nil
+	}
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(token, claims, a.keyFunc); err != nil {
+		// 除了过期，具体的原因只记在服务端，不告诉客户端是签名不对还是iss、aud不匹配
+		zap.L().Debug("JWT验证失败", zap.Error(err))
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, errs.New(codes.Unauthenticated, errs.ReasonInvalidToken, "访问令牌已过期")
+		}
+		return nil, errs.New(codes.Unauthenticated, errs.ReasonInvalidToken, "访问令牌无效")
+	}
+	id := &Identity{Method: MethodJWT}
+	id.Subject, _ = claims.GetSubject()
+	id.Issuer, _ = claims.GetIssuer()
+	if len(id.Subject) == 0 {
+		return nil, errs.New(codes.Unauthenticated, errs.ReasonInvalidToken, "访问令牌中没有sub")
+	}
+	id.Roles = stringsClaim(claims[a.rolesClaim])
+	// OAuth2的scope是空格分隔的字符串，也有签发方用scp数组
+	if scope, ok := claims["scope"].(string); ok {
+		id.Scopes = strings.Fields(scope)
+	} else if scp, ok := claims["scp"]; ok {
+		id.Scopes = stringsClaim(scp)
+	} else {
+		id.Scopes = a.defaultScopes
+	}
+	return id, nil
+}
+
+// claim可能是字符串数组，也可能是单个字符串
+func stringsClaim(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
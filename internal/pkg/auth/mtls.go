@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+
+	"go-grpc/internal/pkg/errs"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// MethodMTLS 是客户端证书认证的Identity.Method
+	MethodMTLS = "mtls"
+	// ForwardedCertHeader 是gateway转发REST调用方证书（base64的DER）的metadata
+	// 只有gateway自己的连接带上它才有效，REST请求中同名的头必须在gateway丢掉
+	ForwardedCertHeader = "x-forwarded-client-cert"
+)
+
+// CertAuthenticator 用TLS握手时验证过的客户端证书识别调用方
+// 经过gateway的REST请求，TLS连接的对端是gateway，调用方的证书由gateway通过ForwardedCertHeader转发
+type CertAuthenticator struct {
+	isGateway func(*x509.Certificate) bool
+	scopes    []string
+}
+
+// NewCertAuthenticator 创建CertAuthenticator，isGateway判断证书是不是gateway连接gRPC时出示的，
+// scopes授予所有证书认证的调用方
+func NewCertAuthenticator(isGateway func(*x509.Certificate) bool, scopes []string) *CertAuthenticator {
+	return &CertAuthenticator{isGateway: isGateway, scopes: scopes}
+}
+
+func (a *CertAuthenticator) Authenticate(ctx context.Context, md metadata.MD) (*Identity, error) {
+	cert := PeerCertificate(ctx)
+	if cert == nil {
+		return nil, nil
+	}
+	if a.isGateway != nil && a.isGateway(cert) {
+		values := md.Get(ForwardedCertHeader)
+		// REST调用方没有出示证书，gateway本身不能作为调用方
+		if len(values) == 0 {
+			return nil, nil
+		}
+		der, err := base64.StdEncoding.DecodeString(values[0])
+		if err != nil {
+			return nil, errs.New(codes.Unauthenticated, errs.ReasonUnauthenticated, "转发的客户端证书格式错误")
+		}
+		if cert, err = x509.ParseCertificate(der); err != nil {
+			return nil, errs.New(codes.Unauthenticated, errs.ReasonUnauthenticated, "转发的客户端证书格式错误")
+		}
+	}
+	id := CertIdentity(cert)
+	id.Scopes = a.scopes
+	return id, nil
+}
+
+// PeerCertificate 返回对端在TLS握手时出示并且通过验证的证书，没有时返回nil
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// CertIdentity 把证书映射成调用方：Subject依次取URI SAN（比如SPIFFE ID）、DNS SAN、邮箱，都没有时用CN，OU作为角色
+func CertIdentity(cert *x509.Certificate) *Identity {
+	subject := cert.Subject.CommonName
+	switch {
+	case len(cert.URIs) > 0:
+		subject = cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		subject = cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		subject = cert.EmailAddresses[0]
+	}
+	return &Identity{
+		Subject: subject,
+		Issuer:  cert.Issuer.CommonName,
+		Method:  MethodMTLS,
+		Roles:   cert.Subject.OrganizationalUnit,
+	}
+}
@@ -0,0 +1,30 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// 找不到对应的blob时返回这个错误，调用方可以用它来区分NotFound
+var ErrNotFound = errors.New("blob不存在")
+
+// Object是一个打开的blob，需要支持Seek，这样HTTP的Range下载才能直接用http.ServeContent
+type Object interface {
+	io.Reader
+	io.Seeker
+	io.Closer
+	Size() int64
+	ModTime() time.Time
+}
+
+// BlobStore是附件内容的存储抽象，service只依赖这个接口，具体存在本地文件还是对象存储由实现决定
+type BlobStore interface {
+	// 把r的内容全部写入key，返回写入的字节数
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// 打开key对应的内容，不存在时返回ErrNotFound
+	Open(ctx context.Context, key string) (Object, error)
+	// 删除key对应的内容，不存在时不报错
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,267 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 本地生成的文件名，和配置中默认的路径一致
+const (
+	CAFile        = "ca.pem"
+	CAKeyFile     = "ca.key"
+	ServerFile    = "server.pem"
+	ServerKeyFile = "server.key"
+	ClientFile    = "client.pem"
+	ClientKeyFile = "client.key"
+)
+
+// 证书快过期时重新签发
+const renewBefore = 7 * 24 * time.Hour
+
+// CA 是本地开发用的自签名CA，用来签发服务端和客户端证书
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// Pair 是PEM编码的证书和私钥
+type Pair struct {
+	CertPEM []byte
+	KeyPEM  []byte
+}
+
+// NewCA 生成一个自签名的CA
+func NewCA(commonName string, validFor time.Duration) (*CA, *Pair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := newTemplate(commonName, validFor)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	pair, err := encodePair(der, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &CA{Cert: cert, Key: key}, pair, nil
+}
+
+// LoadCA 读取之前生成的CA
+func LoadCA(certPath, keyPath string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !cert.IsCA {
+		return nil, fmt.Errorf("%s不是CA证书", certPath)
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// IssueServer 签发服务端证书，hosts中的IP作为IP SAN，其余的作为DNS SAN
+// 证书同时可以用于客户端认证，开启mTLS时gateway用它连接gRPC
+func (ca *CA) IssueServer(commonName string, hosts []string, validFor time.Duration) (*Pair, error) {
+	template, err := newTemplate(commonName, validFor)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	return ca.issue(template)
+}
+
+// IssueClient 签发客户端证书，CN是调用方，OU作为调用方的角色
+func (ca *CA) IssueClient(commonName string, units []string, validFor time.Duration) (*Pair, error) {
+	template, err := newTemplate(commonName, validFor)
+	if err != nil {
+		return nil, err
+	}
+	template.Subject.OrganizationalUnit = units
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return ca.issue(template)
+}
+
+func (ca *CA) issue(template *x509.Certificate) (*Pair, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	return encodePair(der, key)
+}
+
+func newTemplate(commonName string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"go-grpc dev"}, CommonName: commonName},
+		// 容忍一点时钟偏差
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(validFor),
+	}, nil
+}
+
+func encodePair(der []byte, key *ecdsa.PrivateKey) (*Pair, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Pair{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// Write 写入证书和私钥，私钥只有自己可读
+// 先写临时文件再重命名，正在监听证书文件的服务不会读到写了一半的文件
+func (p *Pair) Write(certPath, keyPath string) error {
+	if err := writeFile(keyPath, p.KeyPEM, 0600); err != nil {
+		return err
+	}
+	return writeFile(certPath, p.CertPEM, 0644)
+}
+
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// DevOptions 是本地开发用证书的选项
+type DevOptions struct {
+	Dir string
+	// 服务端证书的CN，和配置中的commonName一致
+	CommonName string
+	// 服务端证书的SAN，域名或者IP
+	Hosts []string
+	// 客户端证书的CN和OU，CN为空时不生成客户端证书
+	ClientName  string
+	ClientUnits []string
+	ValidFor    time.Duration
+	// 重新生成所有文件，包括CA
+	Force bool
+}
+
+// EnsureDev 保证Dir中有可用的CA、服务端证书和客户端证书，返回是否生成了新的文件
+// 已有的CA会继续使用，这样客户端已经信任的CA不会变；服务端证书缺少SAN或者快过期时重新签发
+func EnsureDev(opts DevOptions) (bool, error) {
+	path := func(name string) string { return filepath.Join(opts.Dir, name) }
+	var ca *CA
+	if !opts.Force {
+		if loaded, err := LoadCA(path(CAFile), path(CAKeyFile)); err == nil && usable(loaded.Cert, nil) {
+			ca = loaded
+		}
+	}
+	// CA换了之后，原来签发的证书都要重新签发
+	renewed := false
+	if ca == nil {
+		var pair *Pair
+		var err error
+		// CA的有效期长一些，签发的证书可以多次更新
+		if ca, pair, err = NewCA("go-grpc dev CA", 10*opts.ValidFor); err != nil {
+			return false, err
+		}
+		if err := pair.Write(path(CAFile), path(CAKeyFile)); err != nil {
+			return false, err
+		}
+		renewed = true
+	}
+
+	generated := renewed
+	if renewed || !issuedBy(path(ServerFile), path(ServerKeyFile), ca, opts.Hosts) {
+		pair, err := ca.IssueServer(opts.CommonName, opts.Hosts, opts.ValidFor)
+		if err != nil {
+			return false, err
+		}
+		if err := pair.Write(path(ServerFile), path(ServerKeyFile)); err != nil {
+			return false, err
+		}
+		generated = true
+	}
+	if len(opts.ClientName) > 0 && (renewed || !issuedBy(path(ClientFile), path(ClientKeyFile), ca, nil)) {
+		pair, err := ca.IssueClient(opts.ClientName, opts.ClientUnits, opts.ValidFor)
+		if err != nil {
+			return false, err
+		}
+		if err := pair.Write(path(ClientFile), path(ClientKeyFile)); err != nil {
+			return false, err
+		}
+		generated = true
+	}
+	return generated, nil
+}
+
+// 证书和私钥匹配、是由ca签发的、没有快过期，并且包含了所有的hosts
+func issuedBy(certPath, keyPath string, ca *CA, hosts []string) bool {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil || cert.CheckSignatureFrom(ca.Cert) != nil {
+		return false
+	}
+	return usable(cert, hosts)
+}
+
+func usable(cert *x509.Certificate, hosts []string) bool {
+	if time.Now().Add(renewBefore).After(cert.NotAfter) {
+		return false
+	}
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+// certs 管理服务端的TLS证书，证书文件变化时自动重新加载，不需要重启服务
+// 本地开发时可以用EnsureDev生成自签名的CA和证书，不需要在仓库中提交私钥
+package certs
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// 证书和私钥通常是先后写入的，等文件不再变化之后再加载
+const reloadDelay = 500 * time.Millisecond
+
+// Manager 持有当前的证书，通过tls.Config的GetCertificate提供给每次握手
+// 加载失败时（比如只写了一半）继续使用原来的证书
+type Manager struct {
+	certPath string
+	keyPath  string
+	// *tls.Certificate，Leaf已经解析好
+	cert atomic.Value
+	// 加载过的所有证书的指纹，证书轮换之前建立的连接还在用旧证书
+	mu     sync.Mutex
+	loaded map[[sha256.Size]byte]bool
+}
+
+// NewManager 加载证书，文件有问题时返回错误
+func NewManager(certPath, keyPath string) (*Manager, error) {
+	m := &Manager{certPath: certPath, keyPath: keyPath, loaded: make(map[[sha256.Size]byte]bool)}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload 重新读取证书和私钥，成功之后新的握手使用新证书，已经建立的连接不受影响
+func (m *Manager) Reload() error {
+	pair, err := tls.LoadX509KeyPair(m.certPath, m.keyPath)
+	if err != nil {
+		return err
+	}
+	if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.loaded[sha256.Sum256(pair.Leaf.Raw)] = true
+	m.mu.Unlock()
+	m.cert.Store(&pair)
+	return nil
+}
+
+// Loaded 判断证书是不是自己加载过的，用来识别gateway用服务端证书建立的连接
+func (m *Manager) Loaded(cert *x509.Certificate) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.loaded[sha256.Sum256(cert.Raw)]
+}
+
+// Certificate 返回当前的证书
+func (m *Manager) Certificate() *tls.Certificate {
+	return m.cert.Load().(*tls.Certificate)
+}
+
+// Leaf 返回当前证书解析后的x509证书
+func (m *Manager) Leaf() *x509.Certificate {
+	return m.Certificate().Leaf
+}
+
+// GetCertificate 用于服务端的tls.Config
+func (m *Manager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.Certificate(), nil
+}
+
+// GetClientCertificate 用于客户端的tls.Config，比如gateway连接gRPC时出示服务端证书
+func (m *Manager) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return m.Certificate(), nil
+}
+
+// Watch 监听证书文件的变化并重新加载，直到ctx结束
+// 监听的是所在的目录，替换文件（mv、k8s的secret更新符号链接）也能发现
+func (m *Manager) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	dirs := map[string]bool{filepath.Dir(m.certPath): true, filepath.Dir(m.keyPath): true}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	// 一次更新会产生多个事件，合并成一次加载
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if m.watched(event.Name) || filepath.Base(event.Name) == "..data" {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			zap.L().Warn("监听证书文件出错", zap.Error(err))
+		case <-timer.C:
+			if err := m.Reload(); err != nil {
+				zap.L().Error("重新加载证书失败，继续使用原来的证书", zap.String("cert", m.certPath), zap.Error(err))
+				continue
+			}
+			leaf := m.Leaf()
+			zap.L().Info("重新加载证书", zap.String("cert", m.certPath),
+				zap.String("subject", leaf.Subject.String()), zap.Time("notAfter", leaf.NotAfter))
+		}
+	}
+}
+
+func (m *Manager) watched(name string) bool {
+	name = filepath.Clean(name)
+	return name == filepath.Clean(m.certPath) || name == filepath.Clean(m.keyPath)
+}
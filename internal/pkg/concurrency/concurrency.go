@@ -0,0 +1,222 @@
+// concurrency 自适应地限制同时处理的请求数，超过限制的请求直接返回Unavailable，而不是排队等数据库连接
+// 每个请求在handler中通过connect占用一个sql.Conn，限制的上限按连接池的大小配置，这样连接池不会被耗尽
+// 限制按AIMD调整：请求的延迟超过目标或者返回了Unavailable、DeadlineExceeded时按比例减小，否则慢慢增大
+// 被拒绝的次数累计在concurrency_shed_total指标中，当前的限制和处理中的请求数在concurrency_limit、concurrency_inflight中
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"sync"
+	"time"
+
+	"go-grpc/internal/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 监控指标
+var (
+	// Shed 按方法统计的被拒绝的请求数
+	Shed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "concurrency_shed_total",
+		Help: "Total number of calls shed by the adaptive concurrency limiter.",
+	}, []string{"method"})
+	limitGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "concurrency_limit",
+		Help: "Current adaptive concurrency limit.",
+	})
+	inflightGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "concurrency_inflight",
+		Help: "Number of calls currently holding a concurrency slot.",
+	})
+)
+
+// Options 是限制的范围和调整的方式
+type Options struct {
+	// 启动时的限制，为0时取Max的一半
+	Initial int
+	// 限制的范围，Max一般是数据库连接池的大小
+	Min int
+	Max int
+	// unary请求的延迟超过这个值时认为已经过载
+	LatencyTarget time.Duration
+	// 过载时限制乘以这个系数，0到1之间
+	Backoff float64
+	// 不受限制的方法，比如健康检查，支持path.Match的通配符
+	Exempt []string
+}
+
+// Limiter 记录当前的限制和处理中的请求数
+type Limiter struct {
+	opts Options
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	// 上一次减小限制的时间，同一批一起变慢的请求只减小一次
+	lastDecrease time.Time
+	// 测试时替换成固定的时钟
+	now func() time.Time
+}
+
+// New 检查选项并创建Limiter
+func New(opts Options) (*Limiter, error) {
+	if opts.Min < 1 {
+		opts.Min = 1
+	}
+	if opts.Max < opts.Min {
+		return nil, fmt.Errorf("并发限制的max（%d）不能小于min（%d）", opts.Max, opts.Min)
+	}
+	if opts.Initial == 0 {
+		opts.Initial = opts.Max / 2
+	}
+	if opts.Initial < opts.Min {
+		opts.Initial = opts.Min
+	}
+	if opts.Initial > opts.Max {
+		return nil, fmt.Errorf("并发限制的initial（%d）不能大于max（%d）", opts.Initial, opts.Max)
+	}
+	if opts.LatencyTarget <= 0 {
+		return nil, fmt.Errorf("并发限制的latencyTarget必须大于0")
+	}
+	if opts.Backoff <= 0 || opts.Backoff >= 1 {
+		return nil, fmt.Errorf("并发限制的backoff必须在0和1之间")
+	}
+	for _, m := range opts.Exempt {
+		if _, err := path.Match(m, ""); err != nil {
+			return nil, fmt.Errorf("并发限制的方法%s格式错误：%v", m, err)
+		}
+	}
+	l := &Limiter{opts: opts, limit: float64(opts.Initial), now: time.Now}
+	limitGauge.Set(l.limit)
+	return l, nil
+}
+
+// Exempt 返回method是否不受限制
+func (l *Limiter) Exempt(method string) bool {
+	for _, m := range l.opts.Exempt {
+		if ok, _ := path.Match(m, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Limit 返回当前的限制
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Acquire 占用一个位置，返回的函数在请求处理完之后调用，超过限制时返回Unavailable错误
+// 释放时传入请求的结果，sample为false时不参与调整限制，比如持续时间和负载无关的流
+func (l *Limiter) Acquire(method string) (func(err error, sample bool), error) {
+	l.mu.Lock()
+	if l.inflight >= int(l.limit) {
+		l.mu.Unlock()
+		Shed.WithLabelValues(method).Inc()
+		return nil, errs.New(codes.Unavailable, errs.ReasonOverloaded, "服务繁忙，请稍后重试")
+	}
+	l.inflight++
+	inflightGauge.Inc()
+	l.mu.Unlock()
+
+	start := l.now()
+	var once sync.Once
+	return func(err error, sample bool) {
+		once.Do(func() { l.release(start, err, sample) })
+	}, nil
+}
+
+func (l *Limiter) release(start time.Time, err error, sample bool) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 占用的位置数是释放之前的，用来判断限制是不是真的被用到了
+	used := l.inflight
+	l.inflight--
+	inflightGauge.Dec()
+	if !sample {
+		return
+	}
+	if overloaded(err) || now.Sub(start) > l.opts.LatencyTarget {
+		// 只有在上一次减小之后开始的请求才会再次减小，避免一批慢请求把限制直接降到最小
+		if start.After(l.lastDecrease) {
+			l.limit = math.Max(float64(l.opts.Min), l.limit*l.opts.Backoff)
+			l.lastDecrease = now
+		}
+	} else if used*2 >= int(l.limit) {
+		// 请求很少时延迟低不能说明可以承受更多的请求，所以只在用到了一半以上时增大，每一轮大约加1
+		l.limit = math.Min(float64(l.opts.Max), l.limit+1/l.limit)
+	}
+	limitGauge.Set(l.limit)
+}
+
+// handler panic时释放位置用的错误，panic之前的耗时说明不了负载，不能当成一次很快的成功
+var errPanicked = status.Error(codes.Internal, "handler panic")
+
+// 后端不可用或者超时说明已经过载，客户端取消的请求不算；handler panic时保守地按过载处理
+func overloaded(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == errPanicked {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return err == context.DeadlineExceeded
+}
+
+// releaseOnPanic 在defer中调用，handler panic时按errPanicked释放位置，然后继续panic，交给外层的recovery处理
+func releaseOnPanic(release func(err error, sample bool)) {
+	if r := recover(); r != nil {
+		release(errPanicked, true)
+		panic(r)
+	}
+}
+
+// UnaryServerInterceptor 在调用handler之前占用位置，按延迟和结果调整限制
+// 在defer中释放，handler panic时位置也会还回去，并且按过载减小限制
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+		if l.Exempt(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		release, err := l.Acquire(info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer releaseOnPanic(release)
+		res, err = handler(ctx, req)
+		release(err, true)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor 流在整个处理期间占用一个位置，流的持续时间取决于数据量，只有过载的错误参与调整
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		if l.Exempt(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		release, err := l.Acquire(info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer releaseOnPanic(release)
+		err = handler(srv, ss)
+		release(err, overloaded(err))
+		return err
+	}
+}
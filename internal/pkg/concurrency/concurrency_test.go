@@ -0,0 +1,193 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 手动推进的时钟，延迟完全由测试决定
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newLimiter(t *testing.T, initial, min, max int) (*Limiter, *fakeClock) {
+	t.Helper()
+	l, err := New(Options{Initial: initial, Min: min, Max: max, LatencyTarget: 100 * time.Millisecond, Backoff: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Date(2021, 6, 1, 8, 0, 0, 0, time.UTC)}
+	l.now = clock.now
+	return l, clock
+}
+
+func acquire(t *testing.T, l *Limiter) func(error, bool) {
+	t.Helper()
+	release, err := l.Acquire("/v2.ToDoService/Read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return release
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		latency time.Duration
+		err     error
+		want    float64
+	}{
+		{"超过延迟目标", 200 * time.Millisecond, nil, 5},
+		{"Unavailable", time.Millisecond, status.Error(codes.Unavailable, "x"), 5},
+		{"DeadlineExceeded", time.Millisecond, context.DeadlineExceeded, 5},
+		{"不低于Min", 200 * time.Millisecond, nil, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newLimiter(t, 10, 5, 20)
+			if tt.name == "不低于Min" {
+				l.limit = 6
+			}
+			release := acquire(t, l)
+			clock.advance(tt.latency)
+			release(tt.err, true)
+			if l.limit != tt.want {
+				t.Fatalf("限制应该是%v，结果是%v", tt.want, l.limit)
+			}
+		})
+	}
+}
+
+func TestBackoffOncePerBatch(t *testing.T) {
+	l, clock := newLimiter(t, 16, 1, 32)
+	// 同时开始的一批请求都变慢了，只减小一次
+	r1, r2 := acquire(t, l), acquire(t, l)
+	clock.advance(time.Second)
+	r1(nil, true)
+	r2(nil, true)
+	if l.limit != 8 {
+		t.Fatalf("同一批慢请求应该只减小一次，结果是%v", l.limit)
+	}
+	// 减小之后开始的慢请求会再次减小
+	clock.advance(time.Millisecond)
+	r3 := acquire(t, l)
+	clock.advance(time.Second)
+	r3(nil, true)
+	if l.limit != 4 {
+		t.Fatalf("减小之后开始的慢请求应该再次减小，结果是%v", l.limit)
+	}
+}
+
+func TestGrowth(t *testing.T) {
+	l, clock := newLimiter(t, 4, 1, 5)
+	// 只用到了不到一半，不增大
+	r := acquire(t, l)
+	clock.advance(time.Millisecond)
+	r(nil, true)
+	if l.limit != 4 {
+		t.Fatalf("用到的位置不到一半时不应该增大，结果是%v", l.limit)
+	}
+	// 用到了一半，每个快速成功的请求加1/limit
+	r1, r2 := acquire(t, l), acquire(t, l)
+	clock.advance(time.Millisecond)
+	r1(nil, true)
+	if l.limit != 4.25 {
+		t.Fatalf("限制应该是4.25，结果是%v", l.limit)
+	}
+	r3 := acquire(t, l)
+	r2(errors.New("业务错误不算过载"), true)
+	r3(nil, false)
+	if l.limit <= 4.25 {
+		t.Fatalf("不是过载的错误也应该增大，结果是%v", l.limit)
+	}
+	// 不超过Max
+	l.limit = 5
+	r1, r2, r3 = acquire(t, l), acquire(t, l), acquire(t, l)
+	r1(nil, true)
+	r2(nil, false)
+	r3(nil, true)
+	if l.limit != 5 {
+		t.Fatalf("限制不能超过Max，结果是%v", l.limit)
+	}
+	if l.inflight != 0 {
+		t.Fatalf("位置都应该还回去，结果还有%d个", l.inflight)
+	}
+}
+
+func TestShed(t *testing.T) {
+	l, _ := newLimiter(t, 2, 2, 2)
+	method := "/v2.ToDoService/Create"
+	before := testutil.ToFloat64(Shed.WithLabelValues(method))
+	r1, r2 := acquire(t, l), acquire(t, l)
+	if _, err := l.Acquire(method); status.Code(err) != codes.Unavailable {
+		t.Fatalf("超过限制时应该返回Unavailable，结果是%v", err)
+	}
+	if got := testutil.ToFloat64(Shed.WithLabelValues(method)); got != before+1 {
+		t.Fatalf("concurrency_shed_total应该加1，结果是%v", got-before)
+	}
+	// 重复释放只算一次
+	r1(nil, false)
+	r1(nil, false)
+	if l.inflight != 1 {
+		t.Fatalf("重复释放不应该多还位置，结果是%d", l.inflight)
+	}
+	release, err := l.Acquire(method)
+	if err != nil {
+		t.Fatalf("释放之后应该可以再占用，结果是%v", err)
+	}
+	release(nil, false)
+	r2(nil, false)
+}
+
+func TestUnaryPanic(t *testing.T) {
+	l, clock := newLimiter(t, 4, 1, 8)
+	// 让限制被用到一半以上，否则看不出是不是被当成了快速的成功
+	other := acquire(t, l)
+	defer other(nil, false)
+	interceptor := l.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/v2.ToDoService/Create"}
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("panic应该继续向外传递，结果是%v", r)
+			}
+		}()
+		interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			clock.advance(time.Millisecond)
+			panic("boom")
+		})
+	}()
+	if l.inflight != 1 {
+		t.Fatalf("panic之后位置应该还回去，结果还有%d个", l.inflight)
+	}
+	if l.limit != 2 {
+		t.Fatalf("panic应该按过载减小限制，结果是%v", l.limit)
+	}
+}
+
+func TestStreamPanic(t *testing.T) {
+	l, _ := newLimiter(t, 4, 1, 8)
+	interceptor := l.StreamServerInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/v2.ToDoService/Export"}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic应该继续向外传递")
+			}
+		}()
+		interceptor(nil, nil, info, func(srv interface{}, ss grpc.ServerStream) error {
+			panic("boom")
+		})
+	}()
+	if l.inflight != 0 || l.limit != 2 {
+		t.Fatalf("panic之后应该还回位置并减小限制，结果是inflight=%d limit=%v", l.inflight, l.limit)
+	}
+}
@@ -0,0 +1,180 @@
+// envelope 字段级的信封加密：每个值用一个随机生成的数据密钥做AES-GCM加密，数据密钥再用本地配置的主密钥加密，和密文保存在一起
+// 主密钥有多个，新写入的值用当前的主密钥，旧的主密钥只用来解密，轮换之后用re-encrypt命令把旧的值换成当前的主密钥
+// 加密后的值是一个字符串：enc:v1:<主密钥ID>:<加密的数据密钥>:<密文>，后两段是base64，没有这个前缀的值按明文处理
+// 明文本身以enc:或者plain:开头时保存成plain:<明文>，否则调用方可以写入一个看起来像密文的明文，之后这一行就读不出来了
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// 加密后的值的前缀，格式变化时升级版本号
+const prefix = "enc:v1:"
+
+// 需要转义的明文的前缀，只加在会被误认的明文前面，其他明文保存的样子不变，开启加密之前写入的值不需要迁移
+const (
+	plainPrefix    = "plain:"
+	reservedPrefix = "enc:"
+)
+
+// 数据密钥的长度，AES-256
+const dataKeySize = 32
+
+var encoding = base64.RawStdEncoding
+
+// Keyring 保存所有的主密钥，active为空时不加密，只解密已经加密的值
+type Keyring struct {
+	keys   map[string]cipher.AEAD
+	active string
+}
+
+// New 创建Keyring，keys是主密钥ID到密钥的映射，密钥是16、24或者32字节的AES密钥
+func New(keys map[string][]byte, active string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD, len(keys)), active: active}
+	for id, key := range keys {
+		if len(id) == 0 || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("主密钥ID %q 不能为空或者包含冒号", id)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("主密钥%s无效：%v", id, err)
+		}
+		k.keys[id] = aead
+	}
+	if _, ok := k.keys[active]; len(active) > 0 && !ok {
+		return nil, fmt.Errorf("找不到当前的主密钥%s", active)
+	}
+	return k, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Active 返回当前的主密钥ID，为空表示不加密
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+// IsEncrypted 返回stored是不是加密后的值
+func IsEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, prefix)
+}
+
+// KeyID 返回加密stored的主密钥ID，明文返回空字符串
+func KeyID(stored string) string {
+	if !IsEncrypted(stored) {
+		return ""
+	}
+	rest := stored[len(prefix):]
+	if i := strings.IndexByte(rest, ':'); i >= 0 {
+		return rest[:i]
+	}
+	return ""
+}
+
+// Current 返回stored是不是已经是Seal会产生的形式：用当前的主密钥加密，或者不加密时是明文
+// re-encrypt命令跳过这样的值
+func (k *Keyring) Current(stored string) bool {
+	if len(stored) == 0 {
+		return true
+	}
+	return KeyID(stored) == k.active
+}
+
+// Seal 用当前的主密钥加密plaintext，aad是和值绑定的附加数据，比如表名、列名和行的ID，解密时必须一致
+// 不加密或者plaintext为空时保存明文，会被误认成密文的明文加上plain:前缀
+func (k *Keyring) Seal(plaintext string, aad []byte) (string, error) {
+	if len(k.active) == 0 || len(plaintext) == 0 {
+		return escape(plaintext), nil
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(data, []byte(plaintext), aad)
+	if err != nil {
+		return "", err
+	}
+	// 数据密钥和主密钥ID绑定，不能换成另一个主密钥ID解密
+	wrapped, err := seal(k.keys[k.active], dataKey, []byte(k.active))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.active + ":" + encoding.EncodeToString(wrapped) + ":" + encoding.EncodeToString(ciphertext), nil
+}
+
+// Open 解密Seal的结果，明文原样返回，这样开启加密之前写入的值也能读出来
+func (k *Keyring) Open(stored string, aad []byte) (string, error) {
+	if strings.HasPrefix(stored, plainPrefix) {
+		return stored[len(plainPrefix):], nil
+	}
+	if !IsEncrypted(stored) {
+		return stored, nil
+	}
+	parts := strings.Split(stored[len(prefix):], ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("加密的值格式错误")
+	}
+	master, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("找不到主密钥%s", parts[0])
+	}
+	wrapped, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("数据密钥格式错误：%v", err)
+	}
+	ciphertext, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误：%v", err)
+	}
+	dataKey, err := open(master, wrapped, []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("解密数据密钥失败：%v", err)
+	}
+	data, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(data, ciphertext, aad)
+	if err != nil {
+		return "", fmt.Errorf("解密失败：%v", err)
+	}
+	return string(plaintext), nil
+}
+
+func escape(plaintext string) string {
+	if strings.HasPrefix(plaintext, reservedPrefix) || strings.HasPrefix(plaintext, plainPrefix) {
+		return plainPrefix + plaintext
+	}
+	return plaintext
+}
+
+// nonce放在密文的前面
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("密文太短")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+}
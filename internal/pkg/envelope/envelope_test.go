@@ -0,0 +1,163 @@
+package envelope
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 32)
+	aad  = []byte("ToDo.Description:1")
+)
+
+func newKeyring(t *testing.T, keys map[string][]byte, active string) *Keyring {
+	t.Helper()
+	k, err := New(keys, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRoundTrip(t *testing.T) {
+	keyrings := []struct {
+		name string
+		k    *Keyring
+	}{
+		{"加密", newKeyring(t, map[string][]byte{"k1": key1}, "k1")},
+		{"不加密", newKeyring(t, map[string][]byte{"k1": key1}, "")},
+		{"没有主密钥", newKeyring(t, nil, "")},
+	}
+	plaintexts := []string{
+		"",
+		"买牛奶",
+		// 看起来像密文的明文保存之后要能原样读出来
+		"enc:v1:x",
+		"enc:v1:k1:AAAA:BBBB",
+		"enc:v2:future",
+		"plain:",
+		"plain:enc:v1:x",
+	}
+	for _, kr := range keyrings {
+		for _, p := range plaintexts {
+			stored, err := kr.k.Seal(p, aad)
+			if err != nil {
+				t.Fatalf("%s：Seal(%q)失败：%v", kr.name, p, err)
+			}
+			got, err := kr.k.Open(stored, aad)
+			if err != nil {
+				t.Fatalf("%s：Open(Seal(%q))失败：%v", kr.name, p, err)
+			}
+			if got != p {
+				t.Fatalf("%s：Open(Seal(%q)) = %q", kr.name, p, got)
+			}
+			if !kr.k.Current(stored) {
+				t.Fatalf("%s：Seal(%q)的结果应该是当前的形式", kr.name, p)
+			}
+			if len(kr.k.Active()) > 0 && len(p) > 0 && (!IsEncrypted(stored) || KeyID(stored) != "k1" || strings.Contains(stored, p)) {
+				t.Fatalf("%s：%q应该用k1加密，结果是%q", kr.name, p, stored)
+			}
+		}
+	}
+}
+
+func TestOpenLegacyPlaintext(t *testing.T) {
+	k := newKeyring(t, map[string][]byte{"k1": key1}, "k1")
+	got, err := k.Open("开启加密之前写入的", aad)
+	if err != nil || got != "开启加密之前写入的" {
+		t.Fatalf("明文应该原样返回，结果是%q, %v", got, err)
+	}
+	if k.Current("开启加密之前写入的") {
+		t.Fatal("开启加密之后明文需要重新加密")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	k := newKeyring(t, map[string][]byte{"k1": key1}, "k1")
+	stored, err := k.Seal("买牛奶", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(stored[len(prefix):], ":")
+	tests := []struct {
+		name   string
+		k      *Keyring
+		stored string
+		aad    []byte
+	}{
+		{"附加数据不同", k, stored, []byte("ToDo.Description:2")},
+		{"没有附加数据", k, stored, nil},
+		{"找不到主密钥", newKeyring(t, map[string][]byte{"k2": key2}, "k2"), stored, aad},
+		{"同一个ID的主密钥不对", newKeyring(t, map[string][]byte{"k1": key2}, "k1"), stored, aad},
+		{"换了主密钥ID", newKeyring(t, map[string][]byte{"k1": key1, "k2": key1}, "k1"), prefix + "k2:" + parts[1] + ":" + parts[2], aad},
+		{"段数不对", k, prefix + "x", aad},
+		{"多了一段", k, stored + ":x", aad},
+		{"数据密钥不是base64", k, prefix + "k1:!!!:" + parts[2], aad},
+		{"密文不是base64", k, prefix + "k1:" + parts[1] + ":!!!", aad},
+		{"密文太短", k, prefix + "k1:" + parts[1] + ":AAAA", aad},
+		{"密文被修改", k, prefix + "k1:" + parts[1] + ":" + flip(parts[2]), aad},
+		{"数据密钥被修改", k, prefix + "k1:" + flip(parts[1]) + ":" + parts[2], aad},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.k.Open(tt.stored, tt.aad); err == nil {
+				t.Fatalf("应该返回错误，结果是%q", got)
+			}
+		})
+	}
+}
+
+// 改掉base64中间的一个字符
+func flip(s string) string {
+	b := []byte(s)
+	i := len(b) / 2
+	if b[i] == 'A' {
+		b[i] = 'B'
+	} else {
+		b[i] = 'A'
+	}
+	return string(b)
+}
+
+func TestRotation(t *testing.T) {
+	old := newKeyring(t, map[string][]byte{"k1": key1}, "k1")
+	stored, err := old.Seal("买牛奶", aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := newKeyring(t, map[string][]byte{"k1": key1, "k2": key2}, "k2")
+	if k.Current(stored) {
+		t.Fatal("用旧的主密钥加密的值需要重新加密")
+	}
+	got, err := k.Open(stored, aad)
+	if err != nil || got != "买牛奶" {
+		t.Fatalf("旧的主密钥应该还能解密，结果是%q, %v", got, err)
+	}
+	resealed, err := k.Seal(got, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyID(resealed) != "k2" || !k.Current(resealed) {
+		t.Fatalf("重新加密之后应该用k2，结果是%q", resealed)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		keys   map[string][]byte
+		active string
+	}{
+		{"ID为空", map[string][]byte{"": key1}, ""},
+		{"ID包含冒号", map[string][]byte{"k:1": key1}, ""},
+		{"密钥长度不对", map[string][]byte{"k1": key1[:10]}, ""},
+		{"找不到当前的主密钥", map[string][]byte{"k1": key1}, "k2"},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.keys, tt.active); err == nil {
+			t.Fatalf("%s：应该返回错误", tt.name)
+		}
+	}
+}
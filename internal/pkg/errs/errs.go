@@ -0,0 +1,117 @@
+// errs 是服务统一的错误模型
+// 返回给客户端的错误都带有稳定的reason和domain（google.rpc.ErrorInfo），客户端应该按reason判断错误，而不是解析message
+// 底层的原因（数据库驱动的报错等）只在服务端记录日志，不会返回给客户端
+package errs
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Domain 是ErrorInfo中的domain，标识错误来自本服务
+const Domain = "todo.go-grpc"
+
+// 错误的reason，一旦发布就不能修改
+const (
+	ReasonInvalidArgument      = "INVALID_ARGUMENT"
+	ReasonValidationFailed     = "VALIDATION_FAILED"
+	ReasonInvalidPageToken     = "INVALID_PAGE_TOKEN"
+	ReasonUnsupportedField     = "UNSUPPORTED_FIELD"
+	ReasonAttachmentTooLarge   = "ATTACHMENT_TOO_LARGE"
+	ReasonImportTooLarge       = "IMPORT_TOO_LARGE"
+	ReasonToDoNotFound         = "TODO_NOT_FOUND"
+	ReasonAttachmentNotFound   = "ATTACHMENT_NOT_FOUND"
+	ReasonNotFound             = "NOT_FOUND"
+	ReasonAlreadyExists        = "ALREADY_EXISTS"
+	ReasonConcurrentUpdate     = "CONCURRENT_UPDATE"
+	ReasonReferenceViolation   = "REFERENCE_VIOLATION"
+	ReasonStorageUnavailable   = "STORAGE_UNAVAILABLE"
+	ReasonDeadlineExceeded     = "DEADLINE_EXCEEDED"
+	ReasonCanceled             = "CANCELED"
+	ReasonAPIVersionMismatch   = "API_VERSION_MISMATCH"
+	ReasonUnsupportedRequest   = "UNSUPPORTED_REQUEST"
+	ReasonInvalidCalendarToken = "INVALID_CALENDAR_TOKEN"
+	ReasonUnauthenticated      = "UNAUTHENTICATED"
+	ReasonInvalidToken         = "INVALID_TOKEN"
+	ReasonInvalidAPIKey        = "INVALID_API_KEY"
+	ReasonInsufficientScope    = "INSUFFICIENT_SCOPE"
+	ReasonAPIKeyNotFound       = "API_KEY_NOT_FOUND"
+	ReasonAPIKeyRevoked        = "API_KEY_REVOKED"
+	ReasonPermissionDenied     = "PERMISSION_DENIED"
+	ReasonRateLimited          = "RATE_LIMITED"
+	ReasonOverloaded           = "OVERLOADED"
+	ReasonInternal             = "INTERNAL"
+)
+
+// Error 是带reason的gRPC错误，实现了GRPCStatus，可以直接作为handler的返回值
+type Error struct {
+	Code     codes.Code
+	Reason   string
+	Message  string
+	Metadata map[string]string
+	// 只用于服务端排查问题，不会出现在返回给客户端的status中
+	cause error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// GRPCStatus 把错误转换成带ErrorInfo详情的status，grpc和gateway都通过它取得返回给客户端的内容
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.Message)
+	withDetails, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason:   e.Reason,
+		Domain:   Domain,
+		Metadata: e.Metadata,
+	})
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+// New 创建一个错误，kv是成对的metadata键值，比如New(codes.NotFound, ReasonToDoNotFound, "ID='1'找不到", "id", "1")
+func New(code codes.Code, reason, msg string, kv ...string) error {
+	e := &Error{Code: code, Reason: reason, Message: msg}
+	if len(kv) > 0 {
+		e.Metadata = make(map[string]string, len(kv)/2)
+		for i := 0; i+1 < len(kv); i += 2 {
+			e.Metadata[kv[i]] = kv[i+1]
+		}
+	}
+	return e
+}
+
+// InvalidArgument 是客户端参数错误的简写
+func InvalidArgument(reason, msg string, kv ...string) error {
+	return New(codes.InvalidArgument, reason, msg, kv...)
+}
+
+// NotFound 是资源不存在的简写
+func NotFound(reason, msg string, kv ...string) error {
+	return New(codes.NotFound, reason, msg, kv...)
+}
+
+// Internal 记录err，返回不包含err内容的Internal错误
+func Internal(msg string, err error) error {
+	zap.L().Error(msg, zap.String("reason", ReasonInternal), zap.Error(err))
+	return &Error{Code: codes.Internal, Reason: ReasonInternal, Message: msg, cause: err}
+}
+
+// Reason 取出err的reason，不是本包的错误时返回空字符串
+func Reason(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+	return ""
+}
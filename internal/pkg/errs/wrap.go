@@ -0,0 +1,72 @@
+package errs
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MySQL的错误号，见https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	mysqlDupEntry         = 1062
+	mysqlLockWaitTimeout  = 1205
+	mysqlDeadlock         = 1213
+	mysqlRowIsReferenced  = 1451
+	mysqlNoReferencedRow  = 1452
+	mysqlTooManyConns     = 1040
+	mysqlUserTooManyConns = 1203
+	mysqlServerShutdown   = 1053
+)
+
+// Wrap 把数据库、BlobStore以及读取请求流时的错误转换成对应的gRPC错误
+// msg描述的是失败的操作，比如"添加ToDo失败"，err只记录在服务端日志中；err已经是gRPC错误时原样返回
+func Wrap(msg string, err error) error {
+	if _, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		return err
+	}
+	code, reason := classify(err)
+	zap.L().Warn(msg, zap.String("reason", reason), zap.Error(err))
+	return &Error{Code: code, Reason: reason, Message: msg, cause: err}
+}
+
+func classify(err error) (codes.Code, string) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return codes.NotFound, ReasonNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded, ReasonDeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled, ReasonCanceled
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET):
+		return codes.Unavailable, ReasonStorageUnavailable
+	}
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		switch myErr.Number {
+		case mysqlDupEntry:
+			return codes.AlreadyExists, ReasonAlreadyExists
+		case mysqlDeadlock, mysqlLockWaitTimeout:
+			// 事务被回滚了，客户端可以重试整个操作
+			return codes.Aborted, ReasonConcurrentUpdate
+		case mysqlRowIsReferenced, mysqlNoReferencedRow:
+			return codes.FailedPrecondition, ReasonReferenceViolation
+		case mysqlTooManyConns, mysqlUserTooManyConns, mysqlServerShutdown:
+			return codes.Unavailable, ReasonStorageUnavailable
+		}
+		return codes.Internal, ReasonInternal
+	}
+	var netErr *net.OpError
+	if errors.As(err, &netErr) {
+		return codes.Unavailable, ReasonStorageUnavailable
+	}
+	return codes.Internal, ReasonInternal
+}
@@ -0,0 +1,113 @@
+package exchange
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	v2 "go-grpc/api/server/v2"
+)
+
+var csvHeader = []string{"id", "title", "description", "reminder"}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVEncoder(w io.Writer) (*csvEncoder, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return nil, err
+	}
+	return &csvEncoder{w: cw}, nil
+}
+
+func (e *csvEncoder) Encode(td *v2.ToDo) error {
+	reminder := ""
+	if td.Reminder != nil {
+		t, err := ptypes.Timestamp(td.Reminder)
+		if err != nil {
+			return err
+		}
+		reminder = t.Format(time.RFC3339)
+	}
+	return e.w.Write([]string{strconv.FormatInt(td.Id, 10), td.Title, td.Description, reminder})
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type csvDecoder struct {
+	r *csv.Reader
+	// 列名 -> 下标，列的顺序可以和导出的不一样
+	columns map[string]int
+	row     int64
+}
+
+func newCSVDecoder(r io.Reader) (*csvDecoder, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("CSV文件为空")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CSV表头无效：%v", err)
+	}
+	columns := make(map[string]int)
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, fmt.Errorf("CSV表头缺少title列")
+	}
+	return &csvDecoder{r: cr, columns: columns}, nil
+}
+
+func (d *csvDecoder) get(record []string, name string) string {
+	if i, ok := d.columns[name]; ok && i < len(record) {
+		return record[i]
+	}
+	return ""
+}
+
+func (d *csvDecoder) Decode() (*v2.ToDo, error) {
+	record, err := d.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	d.row++
+	if err != nil {
+		if _, ok := err.(*csv.ParseError); ok {
+			return nil, &RowError{Row: d.row, Err: err}
+		}
+		return nil, err
+	}
+	td := &v2.ToDo{
+		Title:       d.get(record, "title"),
+		Description: d.get(record, "description"),
+	}
+	if id := d.get(record, "id"); len(id) > 0 {
+		if td.Id, err = strconv.ParseInt(id, 10, 64); err != nil {
+			return nil, &RowError{Row: d.row, Err: fmt.Errorf("id无效：%s", id)}
+		}
+	}
+	if reminder := d.get(record, "reminder"); len(reminder) > 0 {
+		t, err := time.Parse(time.RFC3339, reminder)
+		if err != nil {
+			return nil, &RowError{Row: d.row, Err: fmt.Errorf("reminder无效：%s", reminder)}
+		}
+		td.Reminder, _ = ptypes.TimestampProto(t)
+	}
+	return td, nil
+}
+
+func (d *csvDecoder) Row() int64 {
+	return d.row
+}
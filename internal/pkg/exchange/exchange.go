@@ -0,0 +1,100 @@
+// exchange 负责ToDo和NDJSON、CSV、iCalendar文件之间的转换，用于批量导入导出
+package exchange
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	v2 "go-grpc/api/server/v2"
+)
+
+// Encoder 把ToDo逐个写成文件，写完之后必须调用Close，有些格式需要写结尾
+type Encoder interface {
+	Encode(td *v2.ToDo) error
+	Close() error
+}
+
+// Decoder 从文件中逐个读出ToDo，读完返回io.EOF
+// 某一行格式有问题时返回*RowError，调用方可以记录下来然后继续读
+type Decoder interface {
+	Decode() (*v2.ToDo, error)
+	// 最近一次Decode所在的行号，从1开始
+	Row() int64
+}
+
+// RowError 是单行的错误，不影响后面的行
+type RowError struct {
+	Row int64
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("第%d行：%v", e.Row, e.Err)
+}
+
+func NewEncoder(f v2.Format, w io.Writer) (Encoder, error) {
+	switch f {
+	case v2.Format_FORMAT_NDJSON:
+		return newNDJSONEncoder(w), nil
+	case v2.Format_FORMAT_CSV:
+		return newCSVEncoder(w)
+	case v2.Format_FORMAT_ICALENDAR:
+		return newICalEncoder(w), nil
+	}
+	return nil, fmt.Errorf("不支持的格式：%v", f)
+}
+
+func NewDecoder(f v2.Format, r io.Reader) (Decoder, error) {
+	switch f {
+	case v2.Format_FORMAT_NDJSON:
+		return newNDJSONDecoder(r), nil
+	case v2.Format_FORMAT_CSV:
+		return newCSVDecoder(r)
+	case v2.Format_FORMAT_ICALENDAR:
+		return newICalDecoder(r), nil
+	}
+	return nil, fmt.Errorf("不支持的格式：%v", f)
+}
+
+// ParseFormat 解析HTTP接口中的format参数，既支持ndjson、csv、ics这样的简写，也支持枚举名
+func ParseFormat(s string) (v2.Format, error) {
+	switch strings.ToLower(s) {
+	case "ndjson", "jsonl", "json":
+		return v2.Format_FORMAT_NDJSON, nil
+	case "csv":
+		return v2.Format_FORMAT_CSV, nil
+	case "ics", "ical", "icalendar":
+		return v2.Format_FORMAT_ICALENDAR, nil
+	}
+	if f, ok := v2.Format_value[strings.ToUpper(s)]; ok && f != 0 {
+		return v2.Format(f), nil
+	}
+	return v2.Format_FORMAT_UNSPECIFIED, fmt.Errorf("不支持的格式：%s", s)
+}
+
+// ContentType 返回导出文件的MIME类型
+func ContentType(f v2.Format) string {
+	switch f {
+	case v2.Format_FORMAT_NDJSON:
+		return "application/x-ndjson"
+	case v2.Format_FORMAT_CSV:
+		return "text/csv; charset=utf-8"
+	case v2.Format_FORMAT_ICALENDAR:
+		return "text/calendar; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// Extension 返回导出文件的扩展名
+func Extension(f v2.Format) string {
+	switch f {
+	case v2.Format_FORMAT_NDJSON:
+		return ".ndjson"
+	case v2.Format_FORMAT_CSV:
+		return ".csv"
+	case v2.Format_FORMAT_ICALENDAR:
+		return ".ics"
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package exchange
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	v2 "go-grpc/api/server/v2"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/proto"
+)
+
+func testToDos(t *testing.T) []*v2.ToDo {
+	t.Helper()
+	reminder, err := ptypes.TimestampProto(time.Date(2021, 6, 1, 8, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*v2.ToDo{
+		{Id: 1, Title: "买牛奶", Description: "全脂的，两瓶", Reminder: reminder},
+		{Id: 2, Title: `quote " comma , semicolon ; backslash \`, Description: "第一行\n第二行", Reminder: reminder},
+		{Id: 3, Title: "没有描述", Reminder: reminder},
+		{Id: 4, Title: strings.Repeat("很长的标题", 30), Description: strings.Repeat("long description ", 20), Reminder: reminder},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range []v2.Format{v2.Format_FORMAT_NDJSON, v2.Format_FORMAT_CSV, v2.Format_FORMAT_ICALENDAR} {
+		t.Run(f.String(), func(t *testing.T) {
+			want := testToDos(t)
+			var buf bytes.Buffer
+			enc, err := NewEncoder(f, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, td := range want {
+				if err := enc.Encode(td); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := enc.Close(); err != nil {
+				t.Fatal(err)
+			}
+			dec, err := NewDecoder(f, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, w := range want {
+				got, err := dec.Decode()
+				if err != nil {
+					t.Fatalf("第%d个ToDo：%v", i+1, err)
+				}
+				if !proto.Equal(got, w) {
+					t.Fatalf("第%d个ToDo读回来是%v，应该是%v", i+1, got, w)
+				}
+			}
+			if _, err := dec.Decode(); err != io.EOF {
+				t.Fatalf("读完之后应该返回io.EOF，结果是%v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeRowErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format v2.Format
+		text   string
+		// 出错的行号，之后的行还能继续读
+		row int64
+	}{
+		{"NDJSON格式错误", v2.Format_FORMAT_NDJSON, "{\"title\":\"a\"}\n{bad\n{\"title\":\"c\"}\n", 2},
+		{"CSV的reminder无效", v2.Format_FORMAT_CSV, "title,reminder\na,\nb,tomorrow\nc,\n", 2},
+		{"CSV的id无效", v2.Format_FORMAT_CSV, "id,title\n1,a\nx,b\n3,c\n", 2},
+		{"ICS的DUE无效", v2.Format_FORMAT_ICALENDAR, "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nSUMMARY:a\r\nEND:VTODO\r\nBEGIN:VTODO\r\nSUMMARY:b\r\nDUE:tomorrow\r\nEND:VTODO\r\nBEGIN:VTODO\r\nSUMMARY:c\r\nEND:VTODO\r\nEND:VCALENDAR\r\n", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dec, err := NewDecoder(tt.format, strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var titles []string
+			for {
+				td, err := dec.Decode()
+				if err == io.EOF {
+					break
+				}
+				if rowErr, ok := err.(*RowError); ok {
+					if rowErr.Row != tt.row {
+						t.Fatalf("出错的行号是%d，应该是%d", rowErr.Row, tt.row)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				titles = append(titles, td.Title)
+			}
+			if strings.Join(titles, ",") != "a,c" {
+				t.Fatalf("出错的行之外应该读出a,c，结果是%v", titles)
+			}
+		})
+	}
+}
+
+func TestNewCSVDecoderErrors(t *testing.T) {
+	for _, text := range []string{"", "id,description\n1,x\n"} {
+		if _, err := NewDecoder(v2.Format_FORMAT_CSV, strings.NewReader(text)); err == nil {
+			t.Fatalf("%q：应该返回错误", text)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		s    string
+		want v2.Format
+		ok   bool
+	}{
+		{"ndjson", v2.Format_FORMAT_NDJSON, true},
+		{"CSV", v2.Format_FORMAT_CSV, true},
+		{"ics", v2.Format_FORMAT_ICALENDAR, true},
+		{"FORMAT_CSV", v2.Format_FORMAT_CSV, true},
+		{"FORMAT_UNSPECIFIED", v2.Format_FORMAT_UNSPECIFIED, false},
+		{"xml", v2.Format_FORMAT_UNSPECIFIED, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseFormat(tt.s)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Fatalf("ParseFormat(%q) = %v, %v", tt.s, got, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package exchange
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/ical"
+)
+
+const (
+	icalProdID = "-//go-grpc//ToDo Service//CN"
+	// UID的格式是todo-{id}@go-grpc，导入时从UID里取回id
+	uidPrefix = "todo-"
+	uidSuffix = "@go-grpc"
+)
+
+// UID 返回ToDo在iCalendar中的唯一标识
+func UID(id int64) string {
+	return uidPrefix + strconv.FormatInt(id, 10) + uidSuffix
+}
+
+type icalEncoder struct {
+	w   *ical.Writer
+	now time.Time
+}
+
+func newICalEncoder(w io.Writer) *icalEncoder {
+	e := &icalEncoder{w: ical.NewWriter(w), now: time.Now()}
+	e.w.Begin("VCALENDAR")
+	e.w.Raw("VERSION", "2.0")
+	e.w.Prop("PRODID", icalProdID)
+	return e
+}
+
+func (e *icalEncoder) Encode(td *v2.ToDo) error {
+	e.w.Begin("VTODO")
+	e.w.Prop("UID", UID(td.Id))
+	e.w.Time("DTSTAMP", e.now)
+	e.w.Prop("SUMMARY", td.Title)
+	if len(td.Description) > 0 {
+		e.w.Prop("DESCRIPTION", td.Description)
+	}
+	if td.Reminder != nil {
+		t, err := ptypes.Timestamp(td.Reminder)
+		if err != nil {
+			return err
+		}
+		e.w.Time("DUE", t)
+		// 在reminder的时间点提醒，日历应用会据此弹出通知
+		e.w.Begin("VALARM")
+		e.w.Raw("ACTION", "DISPLAY")
+		e.w.Prop("DESCRIPTION", td.Title)
+		e.w.Time("TRIGGER", t, "VALUE=DATE-TIME")
+		e.w.End("VALARM")
+	}
+	e.w.End("VTODO")
+	return nil
+}
+
+func (e *icalEncoder) Close() error {
+	e.w.End("VCALENDAR")
+	return e.w.Flush()
+}
+
+// iCalendar的VTODO之间没有行的概念，这里的行号是VTODO的序号
+type icalDecoder struct {
+	r       *ical.Reader
+	pending []*ical.Component
+	row     int64
+}
+
+func newICalDecoder(r io.Reader) *icalDecoder {
+	return &icalDecoder{r: ical.NewReader(r)}
+}
+
+func (d *icalDecoder) Decode() (*v2.ToDo, error) {
+	for len(d.pending) == 0 {
+		cal, err := d.r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if cal.Name != "VCALENDAR" {
+			return nil, fmt.Errorf("第%d行不是VCALENDAR", cal.Line)
+		}
+		for _, c := range cal.Components {
+			if c.Name == "VTODO" {
+				d.pending = append(d.pending, c)
+			}
+		}
+	}
+	c := d.pending[0]
+	d.pending = d.pending[1:]
+	d.row++
+	td := new(v2.ToDo)
+	if p, ok := c.Get("UID"); ok {
+		uid := ical.Unescape(p.Value)
+		if strings.HasPrefix(uid, uidPrefix) && strings.HasSuffix(uid, uidSuffix) {
+			td.Id, _ = strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(uid, uidPrefix), uidSuffix), 10, 64)
+		}
+	}
+	if p, ok := c.Get("SUMMARY"); ok {
+		td.Title = ical.Unescape(p.Value)
+	}
+	if p, ok := c.Get("DESCRIPTION"); ok {
+		td.Description = ical.Unescape(p.Value)
+	}
+	if p, ok := c.Get("DUE"); ok {
+		t, err := ical.ParseTime(p)
+		if err != nil {
+			return nil, &RowError{Row: d.row, Err: fmt.Errorf("DUE无效：%s", p.Value)}
+		}
+		td.Reminder, _ = ptypes.TimestampProto(t)
+	}
+	return td, nil
+}
+
+func (d *icalDecoder) Row() int64 {
+	return d.row
+}
@@ -0,0 +1,66 @@
+package exchange
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	v2 "go-grpc/api/server/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+type ndjsonEncoder struct {
+	w *bufio.Writer
+}
+
+func newNDJSONEncoder(w io.Writer) *ndjsonEncoder {
+	return &ndjsonEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *ndjsonEncoder) Encode(td *v2.ToDo) error {
+	// protojson的Marshal不会输出换行，正好一行一个
+	buf, err := protojson.Marshal(td)
+	if err != nil {
+		return err
+	}
+	e.w.Write(buf)
+	return e.w.WriteByte('\n')
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return e.w.Flush()
+}
+
+type ndjsonDecoder struct {
+	s   *bufio.Scanner
+	row int64
+}
+
+func newNDJSONDecoder(r io.Reader) *ndjsonDecoder {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &ndjsonDecoder{s: s}
+}
+
+func (d *ndjsonDecoder) Decode() (*v2.ToDo, error) {
+	for d.s.Scan() {
+		d.row++
+		line := strings.TrimSpace(d.s.Text())
+		if len(line) == 0 {
+			continue
+		}
+		td := new(v2.ToDo)
+		if err := protojson.Unmarshal([]byte(line), td); err != nil {
+			return nil, &RowError{Row: d.row, Err: err}
+		}
+		return td, nil
+	}
+	if err := d.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func (d *ndjsonDecoder) Row() int64 {
+	return d.row
+}
@@ -0,0 +1,136 @@
+// health 提供存活和就绪检查：标准的grpc.health.v1.Health服务，以及给只能访问HTTP的调用方使用的/healthz、/readyz
+// 存活只表示进程还能处理请求；就绪取决于数据库能不能ping通，服务关闭时变为NOT_SERVING
+package health
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// LivenessService 是gRPC存活探针使用的服务名，进程在就是SERVING，不受数据库影响
+// 就绪探针检查空的服务名或者具体的服务名
+const LivenessService = "liveness"
+
+const (
+	// 检查数据库的间隔
+	checkInterval = 5 * time.Second
+	// 每次ping的超时，要比探针的超时短
+	pingTimeout = 2 * time.Second
+)
+
+// Checker 定期ping数据库，把结果同步到gRPC的健康检查服务中
+type Checker struct {
+	db       *sql.DB
+	server   *health.Server
+	services []string
+
+	mu      sync.Mutex
+	serving bool
+	stopped bool
+}
+
+// NewChecker 创建一个Checker，services是需要报告状态的gRPC服务名，空字符串表示整个服务器，总是会报告
+// 第一次ping之前状态是NOT_SERVING
+func NewChecker(db *sql.DB, services ...string) *Checker {
+	c := &Checker{
+		db:       db,
+		server:   health.NewServer(),
+		services: append([]string{""}, services...),
+	}
+	c.set(healthpb.HealthCheckResponse_NOT_SERVING)
+	c.server.SetServingStatus(LivenessService, healthpb.HealthCheckResponse_SERVING)
+	return c
+}
+
+// Server 返回要注册到grpc server上的健康检查服务
+func (c *Checker) Server() healthpb.HealthServer {
+	return c.server
+}
+
+// Run 立即检查一次，之后每隔一段时间检查一次，直到ctx结束
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		c.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	err := c.db.PingContext(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped || (err == nil) == c.serving {
+		return
+	}
+	c.serving = err == nil
+	if c.serving {
+		zap.L().Info("数据库恢复，服务就绪")
+		c.set(healthpb.HealthCheckResponse_SERVING)
+	} else {
+		zap.L().Warn("数据库不可用，服务未就绪", zap.Error(err))
+		c.set(healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+func (c *Checker) set(s healthpb.HealthCheckResponse_ServingStatus) {
+	for _, name := range c.services {
+		c.server.SetServingStatus(name, s)
+	}
+}
+
+// Shutdown 在服务开始关闭时调用，之后就绪检查都是NOT_SERVING，不会再恢复
+// 存活检查保持SERVING，否则排空请求的过程中进程会被当成异常重启
+// 不能调用health.Server的Shutdown，它会把包括存活检查在内的所有服务都设置为NOT_SERVING
+func (c *Checker) Shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+	c.serving = false
+	c.set(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// Ready 返回当前是否就绪
+func (c *Checker) Ready() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.serving && !c.stopped
+}
+
+// RegisterHandlers 注册/healthz和/readyz，返回200或者503，内容和gRPC的状态名一致
+func (c *Checker) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, healthpb.HealthCheckResponse_SERVING)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if c.Ready() {
+			writeStatus(w, healthpb.HealthCheckResponse_SERVING)
+		} else {
+			writeStatus(w, healthpb.HealthCheckResponse_NOT_SERVING)
+		}
+	})
+}
+
+func writeStatus(w http.ResponseWriter, s healthpb.HealthCheckResponse_ServingStatus) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if s != healthpb.HealthCheckResponse_SERVING {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write([]byte(s.String() + "\n"))
+}
@@ -0,0 +1,43 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestShutdown(t *testing.T) {
+	c := NewChecker(nil, "grpc.ToDoService")
+	// 模拟数据库已经ping通
+	c.serving = true
+	c.set(healthpb.HealthCheckResponse_SERVING)
+	c.Shutdown()
+
+	tests := []struct {
+		service string
+		want    healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{"", healthpb.HealthCheckResponse_NOT_SERVING},
+		{"grpc.ToDoService", healthpb.HealthCheckResponse_NOT_SERVING},
+		{LivenessService, healthpb.HealthCheckResponse_SERVING},
+	}
+	for _, tt := range tests {
+		res, err := c.Server().Check(context.Background(), &healthpb.HealthCheckRequest{Service: tt.service})
+		if err != nil || res.Status != tt.want {
+			t.Fatalf("关闭之后%q应该是%v，结果是%v, %v", tt.service, tt.want, res.GetStatus(), err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	c.RegisterHandlers(mux)
+	for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Fatalf("关闭之后%s应该返回%d，结果是%d", path, want, w.Code)
+		}
+	}
+}
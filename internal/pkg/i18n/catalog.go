@@ -0,0 +1,162 @@
+package i18n
+
+import (
+	"strings"
+
+	"go-grpc/internal/pkg/errs"
+)
+
+// catalog 按reason和语言保存提示信息的模板，{key}会被替换成ErrorInfo中metadata的值
+// 同一种语言可以有多个模板，依次尝试，使用第一个所有占位符都有值的模板，所以具体的写在前面
+var catalog = map[string]map[string][]string{
+	errs.ReasonInvalidArgument: {
+		English: {"The value of '{field}' is invalid.", "The request contains an invalid argument."},
+		Chinese: {"参数'{field}'的值无效。", "请求参数无效。"},
+	},
+	errs.ReasonValidationFailed: {
+		English: {"Some fields of the request are invalid, see the field violations for details."},
+		Chinese: {"请求中有字段未通过校验，详见字段错误列表。"},
+	},
+	errs.ReasonInvalidPageToken: {
+		English: {"The page token is invalid, please start from the first page."},
+		Chinese: {"分页token无效，请从第一页重新开始。"},
+	},
+	errs.ReasonUnsupportedField: {
+		English: {"The field '{field}' cannot be updated."},
+		Chinese: {"字段'{field}'不支持更新。"},
+	},
+	errs.ReasonAttachmentTooLarge: {
+		English: {"The attachment exceeds the size limit of {limit} bytes."},
+		Chinese: {"附件超过了{limit}字节的大小限制。"},
+	},
+	errs.ReasonImportTooLarge: {
+		English: {"The import file exceeds the limit of {limit} rows, please split it."},
+		Chinese: {"导入文件超过了{limit}行的限制，请拆分之后再导入。"},
+	},
+	errs.ReasonToDoNotFound: {
+		English: {"ToDo '{id}' was not found.", "The ToDo was not found."},
+		Chinese: {"找不到ID为'{id}'的ToDo。", "找不到ToDo。"},
+	},
+	errs.ReasonAttachmentNotFound: {
+		English: {"Attachment '{id}' was not found.", "The attachment was not found."},
+		Chinese: {"找不到ID为'{id}'的附件。", "找不到附件。"},
+	},
+	errs.ReasonNotFound: {
+		English: {"The requested resource was not found."},
+		Chinese: {"请求的资源不存在。"},
+	},
+	errs.ReasonAlreadyExists: {
+		English: {"The resource already exists."},
+		Chinese: {"资源已经存在。"},
+	},
+	errs.ReasonConcurrentUpdate: {
+		English: {"The data was modified concurrently, please try again."},
+		Chinese: {"数据正在被其他请求修改，请重试。"},
+	},
+	errs.ReasonReferenceViolation: {
+		English: {"The operation conflicts with related data."},
+		Chinese: {"操作与关联的数据冲突。"},
+	},
+	errs.ReasonStorageUnavailable: {
+		English: {"The service is temporarily unavailable, please try again later."},
+		Chinese: {"服务暂时不可用，请稍后重试。"},
+	},
+	errs.ReasonDeadlineExceeded: {
+		English: {"The request timed out."},
+		Chinese: {"请求超时。"},
+	},
+	errs.ReasonCanceled: {
+		English: {"The request was canceled."},
+		Chinese: {"请求已取消。"},
+	},
+	errs.ReasonAPIVersionMismatch: {
+		English: {"API version '{requested}' is not supported, this endpoint serves '{supported}'."},
+		Chinese: {"不支持API版本'{requested}'，本接口的版本为'{supported}'。"},
+	},
+	errs.ReasonUnsupportedRequest: {
+		English: {"The request is not supported."},
+		Chinese: {"不支持该请求。"},
+	},
+	errs.ReasonInvalidCalendarToken: {
+		English: {"The calendar subscription token is invalid."},
+		Chinese: {"日历订阅token无效。"},
+	},
+	errs.ReasonUnauthenticated: {
+		English: {"Authentication is required, please provide valid credentials."},
+		Chinese: {"需要登录，请提供有效的凭证。"},
+	},
+	errs.ReasonInvalidToken: {
+		English: {"The access token is invalid or has expired."},
+		Chinese: {"访问令牌无效或已过期。"},
+	},
+	errs.ReasonInvalidAPIKey: {
+		English: {"The API key is invalid or has been revoked."},
+		Chinese: {"API key无效或已吊销。"},
+	},
+	errs.ReasonInsufficientScope: {
+		English: {"The credentials do not grant the '{scope}' scope.", "The credentials do not grant the required scope."},
+		Chinese: {"当前凭证没有'{scope}'权限。", "当前凭证没有所需的权限。"},
+	},
+	errs.ReasonAPIKeyNotFound: {
+		English: {"API key '{id}' was not found.", "The API key was not found."},
+		Chinese: {"找不到ID为'{id}'的API key。", "找不到API key。"},
+	},
+	errs.ReasonAPIKeyRevoked: {
+		English: {"API key '{id}' has been revoked.", "The API key has been revoked."},
+		Chinese: {"ID为'{id}'的API key已经吊销。", "API key已经吊销。"},
+	},
+	errs.ReasonPermissionDenied: {
+		English: {"You do not have permission to call '{method}'.", "You do not have permission to perform this operation."},
+		Chinese: {"没有调用'{method}'的权限。", "没有执行该操作的权限。"},
+	},
+	errs.ReasonRateLimited: {
+		English: {"Too many requests, please retry after {retryAfter} seconds.", "Too many requests, please retry later."},
+		Chinese: {"请求太频繁，请{retryAfter}秒后重试。", "请求太频繁，请稍后重试。"},
+	},
+	errs.ReasonOverloaded: {
+		English: {"The service is busy, please try again later."},
+		Chinese: {"服务繁忙，请稍后重试。"},
+	},
+	errs.ReasonInternal: {
+		English: {"An internal error occurred."},
+		Chinese: {"服务内部错误。"},
+	},
+}
+
+// Message 返回reason在locale下的提示信息，没有对应的模板时返回false
+func Message(reason, locale string, metadata map[string]string) (string, bool) {
+	templates, ok := catalog[reason][locale]
+	if !ok {
+		templates, ok = catalog[reason][DefaultLocale]
+	}
+	for _, t := range templates {
+		if msg, ok := render(t, metadata); ok {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// 把模板中的{key}替换成metadata的值，有占位符没有值时返回false
+func render(template string, metadata map[string]string) (string, bool) {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(template, '{')
+		if start < 0 {
+			b.WriteString(template)
+			return b.String(), true
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			b.WriteString(template)
+			return b.String(), true
+		}
+		value, ok := metadata[template[start+1:start+end]]
+		if !ok {
+			return "", false
+		}
+		b.WriteString(template[:start])
+		b.WriteString(value)
+		template = template[start+end+1:]
+	}
+}
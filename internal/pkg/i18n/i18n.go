@@ -0,0 +1,143 @@
+// i18n 按错误的reason提供多语言的提示信息
+// 语言由客户端的Accept-Language决定：gRPC客户端放在accept-language元数据里，HTTP请求经过gateway转发后是grpcgateway-accept-language
+// 本地化之后的提示放在google.rpc.LocalizedMessage详情中，status的message保持不变，方便开发人员排查问题
+package i18n
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go-grpc/internal/pkg/errs"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 支持的语言，使用BCP-47的写法
+const (
+	English = "en-US"
+	Chinese = "zh-CN"
+)
+
+// DefaultLocale 客户端没有指定语言，或者指定的语言都不支持时使用
+const DefaultLocale = Chinese
+
+// 按主语言匹配，比如en-GB匹配English，zh-TW匹配Chinese
+var primaries = map[string]string{
+	"en": English,
+	"zh": Chinese,
+}
+
+// 按顺序查找的元数据键，gateway会给HTTP头加上grpcgateway-前缀
+var metadataKeys = []string{"accept-language", "grpcgateway-accept-language"}
+
+// MatchLocale 解析Accept-Language，按q值从高到低返回第一个支持的语言
+func MatchLocale(acceptLanguage string) string {
+	type candidate struct {
+		tag string
+		q   float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if len(tag) == 0 {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{tag: tag, q: q})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	for _, c := range candidates {
+		if c.tag == "*" {
+			return DefaultLocale
+		}
+		primary := strings.ToLower(strings.SplitN(c.tag, "-", 2)[0])
+		if locale, ok := primaries[primary]; ok {
+			return locale
+		}
+	}
+	return DefaultLocale
+}
+
+// LocaleFromContext 从gRPC的元数据中取出客户端的语言
+func LocaleFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return DefaultLocale
+	}
+	for _, key := range metadataKeys {
+		if values := md.Get(key); len(values) > 0 {
+			return MatchLocale(strings.Join(values, ","))
+		}
+	}
+	return DefaultLocale
+}
+
+// Localize 为带有本服务ErrorInfo的错误加上LocalizedMessage详情，其他错误原样返回
+func Localize(err error, locale string) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			if d.Domain == errs.Domain {
+				info = d
+			}
+		case *errdetails.LocalizedMessage:
+			// 已经本地化过的不再重复添加
+			return err
+		}
+	}
+	if info == nil {
+		return err
+	}
+	msg, ok := Message(info.Reason, locale, info.Metadata)
+	if !ok {
+		return err
+	}
+	withDetails, e := st.WithDetails(&errdetails.LocalizedMessage{Locale: locale, Message: msg})
+	if e != nil {
+		return err
+	}
+	return withDetails.Err()
+}
+
+// UnaryServerInterceptor 按客户端的语言本地化handler返回的错误
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		res, err := handler(ctx, req)
+		if err != nil {
+			return nil, Localize(err, LocaleFromContext(ctx))
+		}
+		return res, nil
+	}
+}
+
+// StreamServerInterceptor 按客户端的语言本地化流式接口返回的错误
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return Localize(handler(srv, ss), LocaleFromContext(ss.Context()))
+	}
+}
@@ -0,0 +1,276 @@
+// ical 实现了RFC 5545 iCalendar格式中用到的那一小部分：内容行的折行、转义和解析
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// 时间统一使用UTC格式，例如20210601T080000Z
+const TimeFormat = "20060102T150405Z"
+
+// 每行最多75个字节，超过的部分要折到下一行，下一行以空格开头
+const maxLineOctets = 75
+
+// Writer 按内容行写iCalendar，行尾是CRLF
+type Writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) Begin(component string) {
+	w.line("BEGIN:" + component)
+}
+
+func (w *Writer) End(component string) {
+	w.line("END:" + component)
+}
+
+// Prop 写一个属性，value会被转义，params形如"VALUE=DATE"，原样输出
+func (w *Writer) Prop(name, value string, params ...string) {
+	w.Raw(name, Escape(value), params...)
+}
+
+// Raw 写一个不需要转义的属性，比如时间、数字
+func (w *Writer) Raw(name, value string, params ...string) {
+	head := name
+	for _, p := range params {
+		head += ";" + p
+	}
+	w.line(head + ":" + value)
+}
+
+func (w *Writer) Time(name string, t time.Time, params ...string) {
+	w.Raw(name, t.UTC().Format(TimeFormat), params...)
+}
+
+func (w *Writer) line(s string) {
+	if w.err != nil {
+		return
+	}
+	// 按字节折行，但不能把一个多字节字符拆开
+	for first := true; len(s) > 0; first = false {
+		limit := maxLineOctets
+		if !first {
+			limit--
+			w.w.WriteByte(' ')
+		}
+		n := len(s)
+		if n > limit {
+			n = limit
+			for n > 0 && !utf8.RuneStart(s[n]) {
+				n--
+			}
+		}
+		w.w.WriteString(s[:n])
+		_, w.err = w.w.WriteString("\r\n")
+		s = s[n:]
+	}
+}
+
+// Flush 把缓冲写出去，返回写入过程中遇到的第一个错误
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
+
+// TEXT类型的值中不能有换行，CRLF、LF和单独的CR都写成\n，读回来都是LF
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+// Escape 转义TEXT类型的值
+func Escape(s string) string {
+	return escaper.Replace(s)
+}
+
+// Unescape 是Escape的逆操作
+func Unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Property 是解析出来的一个内容行
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+	// 所在的行号（折行之前的第一行），用于报错
+	Line int
+}
+
+// Component 是BEGIN和END之间的一个组件，比如VTODO
+type Component struct {
+	Name       string
+	Line       int
+	Props      []Property
+	Components []*Component
+}
+
+// Get 返回第一个同名属性
+func (c *Component) Get(name string) (Property, bool) {
+	for _, p := range c.Props {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+// Reader 按组件读取iCalendar
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+	// 预读的下一行，用于处理折行
+	next     string
+	nextLine int
+	hasNext  bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &Reader{s: s}
+}
+
+// 读取一个逻辑行，把折行拼回来
+func (r *Reader) readLine() (string, int, error) {
+	if !r.hasNext {
+		if !r.s.Scan() {
+			if err := r.s.Err(); err != nil {
+				return "", 0, err
+			}
+			return "", 0, io.EOF
+		}
+		r.line++
+		r.next, r.nextLine, r.hasNext = strings.TrimRight(r.s.Text(), "\r"), r.line, true
+	}
+	cur, curLine := r.next, r.nextLine
+	r.hasNext = false
+	for r.s.Scan() {
+		r.line++
+		l := strings.TrimRight(r.s.Text(), "\r")
+		if strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t") {
+			cur += l[1:]
+			continue
+		}
+		r.next, r.nextLine, r.hasNext = l, r.line, true
+		break
+	}
+	if err := r.s.Err(); err != nil {
+		return "", 0, err
+	}
+	return cur, curLine, nil
+}
+
+func parseProperty(s string, line int) (Property, error) {
+	// 属性名和参数在第一个不在引号内的冒号之前
+	inQuote := false
+	colon := -1
+	for i := 0; i < len(s) && colon < 0; i++ {
+		switch s[i] {
+		case '"':
+			inQuote = !inQuote
+		case ':':
+			if !inQuote {
+				colon = i
+			}
+		}
+	}
+	if colon < 0 {
+		return Property{}, fmt.Errorf("第%d行缺少冒号", line)
+	}
+	parts := strings.Split(s[:colon], ";")
+	p := Property{Name: strings.ToUpper(parts[0]), Value: s[colon+1:], Line: line, Params: make(map[string]string)}
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			p.Params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return p, nil
+}
+
+// Next 读取下一个顶层组件，一般是VCALENDAR，没有更多组件时返回io.EOF
+func (r *Reader) Next() (*Component, error) {
+	var stack []*Component
+	for {
+		l, line, err := r.readLine()
+		if err == io.EOF && len(stack) > 0 {
+			return nil, fmt.Errorf("第%d行开始的%s没有END", stack[0].Line, stack[0].Name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(l)) == 0 {
+			continue
+		}
+		p, err := parseProperty(l, line)
+		if err != nil {
+			return nil, err
+		}
+		switch p.Name {
+		case "BEGIN":
+			c := &Component{Name: strings.ToUpper(p.Value), Line: line}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, fmt.Errorf("第%d行的END:%s没有对应的BEGIN", line, p.Value)
+			}
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return c, nil
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("第%d行的属性不在任何组件内", line)
+			}
+			cur := stack[len(stack)-1]
+			cur.Props = append(cur.Props, p)
+		}
+	}
+}
+
+// ParseTime 解析DATE-TIME或者DATE类型的值，没有时区的时间按UTC处理
+func ParseTime(p Property) (time.Time, error) {
+	v := p.Value
+	if p.Params["VALUE"] == "DATE" || len(v) == 8 {
+		return time.ParseInLocation("20060102", v, time.UTC)
+	}
+	if strings.HasSuffix(v, "Z") {
+		return time.Parse(TimeFormat, v)
+	}
+	loc := time.UTC
+	if tz, ok := p.Params["TZID"]; ok {
+		if l, err := time.LoadLocation(tz); err == nil {
+			loc = l
+		}
+	}
+	return time.ParseInLocation("20060102T150405", v, loc)
+}
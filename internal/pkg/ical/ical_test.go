@@ -0,0 +1,116 @@
+package ical
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, escaped, out string
+	}{
+		{"买牛奶", "买牛奶", "买牛奶"},
+		{`a\b;c,d`, `a\\b\;c\,d`, `a\b;c,d`},
+		{"a\nb", `a\nb`, "a\nb"},
+		{"a\r\nb", `a\nb`, "a\nb"},
+		// 单独的CR也不能原样写出去，否则日历应用会把它当成换行
+		{"a\rb", `a\nb`, "a\nb"},
+		{"a\r", `a\n`, "a\n"},
+		{`\n`, `\\n`, `\n`},
+	}
+	for _, tt := range tests {
+		escaped := Escape(tt.in)
+		if escaped != tt.escaped {
+			t.Fatalf("Escape(%q) = %q，应该是%q", tt.in, escaped, tt.escaped)
+		}
+		if strings.ContainsAny(escaped, "\r\n") {
+			t.Fatalf("Escape(%q)的结果中有换行", tt.in)
+		}
+		if out := Unescape(escaped); out != tt.out {
+			t.Fatalf("Unescape(%q) = %q，应该是%q", escaped, out, tt.out)
+		}
+	}
+}
+
+func TestWriterFolding(t *testing.T) {
+	long := strings.Repeat("提醒", 60) + strings.Repeat("x", 100)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Begin("VCALENDAR")
+	w.Begin("VTODO")
+	w.Prop("SUMMARY", long)
+	w.End("VTODO")
+	w.End("VCALENDAR")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(l) > maxLineOctets {
+			t.Fatalf("第%d行有%d个字节，超过了%d", i+1, len(l), maxLineOctets)
+		}
+		if !utf8.ValidString(l) {
+			t.Fatalf("第%d行拆开了多字节字符：%q", i+1, l)
+		}
+	}
+
+	cal, err := NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.Name != "VCALENDAR" || len(cal.Components) != 1 || cal.Components[0].Name != "VTODO" {
+		t.Fatalf("组件结构不对：%+v", cal)
+	}
+	p, ok := cal.Components[0].Get("SUMMARY")
+	if !ok || Unescape(p.Value) != long {
+		t.Fatalf("折行之后读回来的值不一致：%q", p.Value)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"没有END", "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nEND:VTODO\r\n"},
+		{"END不匹配", "BEGIN:VCALENDAR\r\nEND:VTODO\r\n"},
+		{"缺少冒号", "BEGIN:VCALENDAR\r\nSUMMARY\r\nEND:VCALENDAR\r\n"},
+		{"属性不在组件内", "SUMMARY:x\r\n"},
+	}
+	for _, tt := range tests {
+		if _, err := NewReader(strings.NewReader(tt.text)).Next(); err == nil || err == io.EOF {
+			t.Fatalf("%s：应该返回错误，结果是%v", tt.name, err)
+		}
+	}
+	if _, err := NewReader(strings.NewReader("\r\n")).Next(); err != io.EOF {
+		t.Fatalf("没有组件时应该返回io.EOF，结果是%v", err)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("没有时区数据")
+	}
+	tests := []struct {
+		p    Property
+		want time.Time
+	}{
+		{Property{Value: "20210601T080000Z"}, time.Date(2021, 6, 1, 8, 0, 0, 0, time.UTC)},
+		{Property{Value: "20210601"}, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{Property{Value: "20210601T080000"}, time.Date(2021, 6, 1, 8, 0, 0, 0, time.UTC)},
+		{Property{Value: "20210601T080000", Params: map[string]string{"TZID": "Asia/Shanghai"}}, time.Date(2021, 6, 1, 8, 0, 0, 0, shanghai)},
+	}
+	for _, tt := range tests {
+		got, err := ParseTime(tt.p)
+		if err != nil || !got.Equal(tt.want) {
+			t.Fatalf("ParseTime(%+v) = %v, %v，应该是%v", tt.p, got, err, tt.want)
+		}
+	}
+	if _, err := ParseTime(Property{Value: "tomorrow"}); err == nil {
+		t.Fatal("无效的时间应该返回错误")
+	}
+}
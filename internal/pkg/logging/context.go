@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader 是请求ID的HTTP头和gRPC元数据的键，客户端带了就沿用，没有就生成一个
+const RequestIDHeader = "x-request-id"
+
+type contextKey struct{}
+
+// 一个请求的日志字段，内层的拦截器（比如认证）可以往里面加字段，最后由外层的请求日志一起输出
+type requestFields struct {
+	id     string
+	mu     sync.Mutex
+	fields []zap.Field
+}
+
+func newContext(ctx context.Context, id string) (context.Context, *requestFields) {
+	rf := &requestFields{id: id}
+	return context.WithValue(ctx, contextKey{}, rf), rf
+}
+
+func (rf *requestFields) snapshot() []zap.Field {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	fields := make([]zap.Field, 0, len(rf.fields)+1)
+	fields = append(fields, zap.String("request_id", rf.id))
+	return append(fields, rf.fields...)
+}
+
+// AddFields 给当前请求的日志加上字段，比如认证之后的用户，不在请求中时什么都不做
+func AddFields(ctx context.Context, fields ...zap.Field) {
+	if rf, ok := ctx.Value(contextKey{}).(*requestFields); ok {
+		rf.mu.Lock()
+		rf.fields = append(rf.fields, fields...)
+		rf.mu.Unlock()
+	}
+}
+
+// RequestID 返回当前请求的ID，不在请求中时返回空字符串
+func RequestID(ctx context.Context) string {
+	if rf, ok := ctx.Value(contextKey{}).(*requestFields); ok {
+		return rf.id
+	}
+	return ""
+}
+
+// FromContext 返回带有请求ID的logger，处理请求的代码用它输出日志
+func FromContext(ctx context.Context) *zap.Logger {
+	if id := RequestID(ctx); len(id) > 0 {
+		return zap.L().With(zap.String("request_id", id))
+	}
+	return zap.L()
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// 从元数据中取出请求ID，没有就生成一个，并通过响应头返回给客户端
+func grpcRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDHeader); len(ids) > 0 && len(ids[0]) > 0 {
+			return ids[0]
+		}
+	}
+	return newRequestID()
+}
+
+// 请求的公共字段，gateway转发过来的请求还会带上原始客户端的地址
+func grpcFields(ctx context.Context, method string) []zap.Field {
+	fields := []zap.Field{zap.String("protocol", "grpc"), zap.String("method", method)}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("peer", p.Addr.String()))
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if xff := md.Get("x-forwarded-for"); len(xff) > 0 {
+			fields = append(fields, zap.String("forwarded_for", xff[0]))
+		}
+	}
+	return fields
+}
+
+// 服务端的问题用error级别，客户端的问题用warn级别
+func levelOf(code codes.Code) zapcore.Level {
+	switch code {
+	case codes.OK:
+		return zapcore.InfoLevel
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unavailable, codes.DeadlineExceeded, codes.Unimplemented:
+		return zapcore.ErrorLevel
+	}
+	return zapcore.WarnLevel
+}
+
+func logRPC(rf *requestFields, fields []zap.Field, start time.Time, err error) {
+	st := status.Convert(err)
+	fields = append(fields, rf.snapshot()...)
+	fields = append(fields, zap.String("code", st.Code().String()), zap.Duration("latency", time.Since(start)))
+	if err != nil {
+		fields = append(fields, zap.String("error", st.Message()))
+	}
+	if ce := zap.L().Check(levelOf(st.Code()), "RPC"); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// UnaryServerInterceptor 为每个unary调用输出一条日志
+func UnaryServerInterceptor(opts Options) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		id := grpcRequestID(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, id))
+		ctx, rf := newContext(ctx, id)
+		res, err := handler(ctx, req)
+		fields := grpcFields(ctx, info.FullMethod)
+		if opts.Payload {
+			fields = append(fields, zap.Reflect("request", payload(req, opts.Redact)))
+			if err == nil {
+				fields = append(fields, zap.Reflect("response", payload(res, opts.Redact)))
+			}
+		}
+		logRPC(rf, fields, start, err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor 为每个流式调用在结束时输出一条日志
+func StreamServerInterceptor(opts Options) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		id := grpcRequestID(ss.Context())
+		ss.SetHeader(metadata.Pairs(RequestIDHeader, id))
+		ctx, rf := newContext(ss.Context(), id)
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		logRPC(rf, grpcFields(ctx, info.FullMethod), start, err)
+		return err
+	}
+}
+
+// 替换流的context，让handler能取到请求ID
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
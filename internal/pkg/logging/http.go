@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 记录响应的状态码和大小
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush 导出等流式的响应需要
+func (r *responseRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// HTTPMiddleware 为每个HTTP请求输出一条日志
+// 请求ID写回请求头，gateway会把它转发给gRPC，两边的日志可以对应起来
+func HTTPMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if len(id) == 0 {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx, rf := newContext(r.Context(), id)
+		rec := &responseRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := zapcore.InfoLevel
+		if status >= 500 {
+			level = zapcore.ErrorLevel
+		} else if status >= 400 {
+			level = zapcore.WarnLevel
+		}
+		if ce := zap.L().Check(level, "HTTP"); ce != nil {
+			fields := []zap.Field{
+				zap.String("protocol", r.Proto),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("peer", r.RemoteAddr),
+				zap.Int("status", status),
+				zap.Int64("bytes", rec.bytes),
+				zap.Duration("latency", time.Since(start)),
+			}
+			ce.Write(append(fields, rf.snapshot()...)...)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+// logging 提供结构化的请求日志
+// gRPC拦截器和HTTP中间件为每个请求输出一条日志，包含方法、来源、状态码、耗时、请求ID和用户
+// 同一个请求经过gateway转发时，HTTP和gRPC的两条日志使用同一个请求ID
+package logging
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 日志格式
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Options 是请求日志的选项
+type Options struct {
+	// 是否记录unary请求和响应的内容，流式接口的内容太大，不记录
+	Payload bool
+	// 记录内容时要隐藏的字段，字段名或者JSON名都可以
+	Redact []string
+}
+
+// New 按level（debug、info、warn、error）和format（json、console）创建logger，空值分别使用info和json
+func New(level, format string) (*zap.Logger, error) {
+	var lvl zapcore.Level
+	if len(level) > 0 {
+		if err := lvl.UnmarshalText([]byte(strings.ToLower(level))); err != nil {
+			return nil, fmt.Errorf("无效的日志级别：%s", level)
+		}
+	}
+	var cfg zap.Config
+	switch format {
+	case "", FormatJSON:
+		cfg = zap.NewProductionConfig()
+		cfg.EncoderConfig.TimeKey = "time"
+		cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	case FormatConsole:
+		cfg = zap.NewDevelopmentConfig()
+	default:
+		return nil, fmt.Errorf("无效的日志格式：%s", format)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	// 请求日志已经足够定位问题，采样会丢掉请求日志
+	cfg.Sampling = nil
+	return cfg.Build()
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"encoding/json"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const redacted = "[REDACTED]"
+
+// Redact 返回msg的副本，names中的字段（不区分大小写，字段名或JSON名）字符串替换为[REDACTED]，其他类型清空
+// bytes字段一般是附件或者导入导出的内容，也一起清空
+func Redact(msg proto.Message, names []string) proto.Message {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[strings.ToLower(n)] = true
+	}
+	m := proto.Clone(msg)
+	redact(m.ProtoReflect(), set)
+	return m
+}
+
+func redact(m protoreflect.Message, names map[string]bool) {
+	var clear []protoreflect.FieldDescriptor
+	var mask []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if names[strings.ToLower(string(fd.Name()))] || names[strings.ToLower(fd.JSONName())] {
+			if fd.Kind() == protoreflect.StringKind && fd.Cardinality() != protoreflect.Repeated {
+				mask = append(mask, fd)
+			} else {
+				clear = append(clear, fd)
+			}
+			return true
+		}
+		switch {
+		case fd.Kind() == protoreflect.BytesKind:
+			clear = append(clear, fd)
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+					redact(mv.Message(), names)
+					return true
+				})
+			}
+		case fd.IsList():
+			if fd.Message() != nil {
+				l := v.List()
+				for i := 0; i < l.Len(); i++ {
+					redact(l.Get(i).Message(), names)
+				}
+			}
+		case fd.Message() != nil:
+			redact(v.Message(), names)
+		}
+		return true
+	})
+	for _, fd := range clear {
+		m.Clear(fd)
+	}
+	for _, fd := range mask {
+		m.Set(fd, protoreflect.ValueOfString(redacted))
+	}
+}
+
+// 把消息转换成JSON，用zap.Reflect输出时json.RawMessage会原样嵌入日志
+func payload(msg interface{}, names []string) interface{} {
+	pm, ok := msg.(proto.Message)
+	if !ok {
+		return msg
+	}
+	b, err := protojson.Marshal(Redact(pm, names))
+	if err != nil {
+		return err.Error()
+	}
+	return json.RawMessage(b)
+}
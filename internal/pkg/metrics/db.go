@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// dbStatsCollector 在每次抓取时读取sql.DBStats
+type dbStatsCollector struct {
+	db *sql.DB
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+// RegisterDBStats 注册数据库连接池的指标，name用来区分多个数据库
+func RegisterDBStats(name string, db *sql.DB) error {
+	labels := prometheus.Labels{"db_name": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("go_sql_"+metric, help, nil, labels)
+	}
+	return prometheus.Register(&dbStatsCollector{
+		db:                db,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database."),
+		open:              desc("open_connections", "The number of established connections both in use and idle."),
+		inUse:             desc("in_use_connections", "The number of connections currently in use."),
+		idle:              desc("idle_connections", "The number of idle connections."),
+		waitCount:         desc("wait_count_total", "The total number of connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "The total time blocked waiting for a new connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime."),
+	})
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxLifetimeClosed
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.db.Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(s.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(s.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(s.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(s.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, s.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(s.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(s.MaxLifetimeClosed))
+}
+
+// ToDoStats 是ToDo的业务指标
+type ToDoStats struct {
+	// ToDo的总数
+	Total int64
+	// reminder还没到的ToDo数
+	Pending int64
+}
+
+// 业务指标需要查数据库，抓取太慢时宁可这一次没有数据
+const statsTimeout = 5 * time.Second
+
+type toDoCollector struct {
+	stats   func(ctx context.Context) (ToDoStats, error)
+	total   *prometheus.Desc
+	pending *prometheus.Desc
+}
+
+// RegisterToDoStats 注册ToDo的业务指标，stats在每次抓取时调用
+func RegisterToDoStats(stats func(ctx context.Context) (ToDoStats, error)) error {
+	return prometheus.Register(&toDoCollector{
+		stats:   stats,
+		total:   prometheus.NewDesc("todo_items", "Number of ToDo items.", nil, nil),
+		pending: prometheus.NewDesc("todo_pending_reminders", "Number of ToDo items whose reminder is still in the future.", nil, nil),
+	})
+}
+
+func (c *toDoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.total
+	ch <- c.pending
+}
+
+func (c *toDoCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+	s, err := c.stats(ctx)
+	if err != nil {
+		// 数据库不可用时不能让整个/metrics失败，这一次只是没有业务指标
+		zap.L().Warn("获取ToDo统计失败", zap.Error(err))
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(s.Total))
+	ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(s.Pending))
+}
@@ -0,0 +1,83 @@
+// metrics 提供Prometheus的监控指标：每个RPC和HTTP请求的次数、耗时，数据库连接池的状态，以及ToDo的业务指标
+// 指标都注册在prometheus的默认Registry中，通过promhttp.Handler()暴露
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// 调用方式，和go-grpc-prometheus的取值保持一致，方便复用现成的dashboard
+const (
+	unary        = "unary"
+	clientStream = "client_stream"
+	serverStream = "server_stream"
+	bidiStream   = "bidi_stream"
+)
+
+var (
+	grpcStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_started_total",
+		Help: "Total number of RPCs started on the server.",
+	}, []string{"grpc_type", "grpc_service", "grpc_method"})
+	grpcHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Total number of RPCs completed on the server, regardless of success or failure.",
+	}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"})
+	grpcHandlingSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Histogram of response latency (seconds) of RPCs handled by the server.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"grpc_type", "grpc_service", "grpc_method"})
+)
+
+// FullMethod的格式是/package.Service/Method
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+func observe(typ, fullMethod string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+	grpcHandled.WithLabelValues(typ, service, method, status.Code(err).String()).Inc()
+	grpcHandlingSeconds.WithLabelValues(typ, service, method).Observe(time.Since(start).Seconds())
+}
+
+// UnaryServerInterceptor 统计unary调用的次数和耗时
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		service, method := splitMethod(info.FullMethod)
+		grpcStarted.WithLabelValues(unary, service, method).Inc()
+		start := time.Now()
+		res, err := handler(ctx, req)
+		observe(unary, info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor 统计流式调用的次数和耗时，耗时是整个流的持续时间
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		typ := bidiStream
+		if !info.IsClientStream {
+			typ = serverStream
+		} else if !info.IsServerStream {
+			typ = clientStream
+		}
+		service, method := splitMethod(info.FullMethod)
+		grpcStarted.WithLabelValues(typ, service, method).Inc()
+		start := time.Now()
+		err := handler(srv, ss)
+		observe(typ, info.FullMethod, start, err)
+		return err
+	}
+}
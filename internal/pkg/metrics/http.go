@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// 不同route的上限，超过之后都记为other，避免随意构造的路径把指标撑爆
+const maxRoutes = 200
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests handled by the gateway and custom handlers.",
+	}, []string{"method", "route", "code"})
+	httpRequestSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Histogram of HTTP request latency (seconds).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	routesMu sync.Mutex
+	routes   = make(map[string]bool)
+)
+
+// 把路径中的数字ID替换成{id}，比如/v2/todos/12和/v2/todos/13都是/v2/todos/{id}
+func routeOf(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			segments[i] = "{id}"
+		}
+	}
+	route := strings.Join(segments, "/")
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	if !routes[route] {
+		if len(routes) >= maxRoutes {
+			return "other"
+		}
+		routes[route] = true
+	}
+	return route
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// HTTPMiddleware 统计HTTP请求的次数和耗时
+func HTTPMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := routeOf(r.URL.Path)
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		httpRequestSeconds.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,80 @@
+// middleware 管理gRPC服务的横切功能（日志、恢复panic、认证、监控、校验、限流等）
+// 每个功能按名字注册，启动时根据配置中的顺序组装成unary和stream两条拦截器链，这样不用修改代码就可以按部署开关某个功能
+package middleware
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// Middleware 是一个横切功能，只作用于一种调用方式的可以把另一个留空
+type Middleware struct {
+	Unary  grpc.UnaryServerInterceptor
+	Stream grpc.StreamServerInterceptor
+}
+
+// Factory 在组装拦截器链时调用，可以在这里读取配置、初始化依赖的资源
+type Factory func() (Middleware, error)
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[string]Factory)
+)
+
+// Register 按名字注册一个功能，重复注册会panic
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("middleware %s 重复注册", name))
+	}
+	registry[name] = f
+}
+
+// Names 返回所有已注册的名字
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain 按names的顺序组装拦截器链，排在前面的在外层，先拿到请求、最后拿到返回值
+func Chain(names []string) ([]grpc.ServerOption, error) {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("middleware %s 重复配置", name)
+		}
+		seen[name] = true
+		mu.RLock()
+		f, ok := registry[name]
+		mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("未知的middleware：%s，可用的有%v", name, Names())
+		}
+		m, err := f()
+		if err != nil {
+			return nil, fmt.Errorf("初始化middleware %s 失败：%v", name, err)
+		}
+		if m.Unary != nil {
+			unary = append(unary, m.Unary)
+		}
+		if m.Stream != nil {
+			stream = append(stream, m.Stream)
+		}
+	}
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}, nil
+}
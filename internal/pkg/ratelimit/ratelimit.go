@@ -0,0 +1,213 @@
+// ratelimit 按调用方限流：每个调用方一个令牌桶，通过认证的按Subject区分，没有认证的按IP区分
+// 可以按方法配置不同的速率，比如ReadAll这种查询整张表的接口限制得更严一些
+// gateway转发的请求也经过gRPC的拦截器，超过限制时gRPC返回ResourceExhausted，REST返回429和Retry-After头
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-grpc/internal/pkg/auth"
+	"go-grpc/internal/pkg/errs"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// RetryAfterHeader 是告诉客户端多少秒之后重试的metadata，gateway转成HTTP的Retry-After头
+const RetryAfterHeader = "retry-after"
+
+// 超过这个时间没有请求的令牌桶会被清理
+const idleTimeout = 10 * time.Minute
+
+// Rejected 按方法统计的被限流的请求数
+var Rejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ratelimit_rejected_total",
+	Help: "Total number of calls rejected by the rate limiter.",
+}, []string{"method"})
+
+// Rule 是Methods中的方法的速率，每秒Rate个请求，最多攒Burst个；Rate不大于0时不限流
+// 方法是gRPC的FullMethod，支持path.Match的通配符；同一条规则中的方法共用一个令牌桶
+type Rule struct {
+	Methods []string
+	Rate    float64
+	Burst   int
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter 保存所有调用方的令牌桶
+type Limiter struct {
+	rules []Rule
+	// 没有规则匹配的方法用这个速率，所有这样的方法共用一个令牌桶
+	fallback Rule
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// 当前时间，测试中替换掉
+	now func() time.Time
+}
+
+// New 创建Limiter，规则按顺序匹配，第一个匹配方法的规则生效
+func New(fallback Rule, rules []Rule) (*Limiter, error) {
+	for i, r := range rules {
+		if len(r.Methods) == 0 {
+			return nil, fmt.Errorf("第%d条限流规则的methods不能为空", i+1)
+		}
+		for _, m := range r.Methods {
+			if _, err := path.Match(m, ""); err != nil {
+				return nil, fmt.Errorf("第%d条限流规则的方法%s格式错误：%v", i+1, m, err)
+			}
+		}
+	}
+	return &Limiter{rules: rules, fallback: fallback, buckets: make(map[string]*bucket), now: time.Now}, nil
+}
+
+// 返回方法对应的规则和它的编号，编号用来区分令牌桶
+func (l *Limiter) rule(method string) (Rule, int) {
+	for i, r := range l.rules {
+		for _, m := range r.Methods {
+			if ok, _ := path.Match(m, method); ok {
+				return r, i
+			}
+		}
+	}
+	return l.fallback, -1
+}
+
+// Wait 返回caller调用method之前需要等待的时间，0表示可以立即调用并且已经消耗了一个令牌
+func (l *Limiter) Wait(method, caller string) time.Duration {
+	r, index := l.rule(method)
+	if r.Rate <= 0 {
+		return 0
+	}
+	now := l.now()
+	key := strconv.Itoa(index) + "|" + caller
+
+	l.mu.Lock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		burst := r.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(r.Rate), burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	res := b.limiter.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+	if delay == 0 {
+		return 0
+	}
+	// 不等待，把预定的令牌还回去
+	res.CancelAt(now)
+	return delay
+}
+
+// 清理长时间没有请求的调用方，最多每分钟一次，调用时要持有锁
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Check 检查caller能不能调用method，超过限制时返回ResourceExhausted错误和需要等待的秒数
+func (l *Limiter) Check(method, caller string) (int, error) {
+	delay := l.Wait(method, caller)
+	if delay == 0 {
+		return 0, nil
+	}
+	Rejected.WithLabelValues(method).Inc()
+	seconds := int(math.Ceil(delay.Seconds()))
+	return seconds, errs.New(codes.ResourceExhausted, errs.ReasonRateLimited,
+		fmt.Sprintf("请求太频繁，请%d秒后重试", seconds), "retryAfter", strconv.Itoa(seconds))
+}
+
+// Caller 返回限流用的调用方：通过认证的用Subject，否则用IP
+// gateway转发的请求对端是本机，这时用gateway带过来的x-forwarded-for，其他对端带的x-forwarded-for不可信
+func Caller(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok {
+		return "subject:" + id.Subject
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "ip:unknown"
+	}
+	ip := hostOf(p.Addr.String())
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.IsLoopback() {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if xff := md.Get("x-forwarded-for"); len(xff) > 0 && len(xff[0]) > 0 {
+				// gateway把原始客户端的地址追加在最后
+				ip = lastForwarded(xff[len(xff)-1])
+			}
+		}
+	}
+	return "ip:" + ip
+}
+
+// HTTPCaller 是直接处理的HTTP请求的调用方，ctx中有认证后的身份时用Subject
+func HTTPCaller(ctx context.Context, remoteAddr string) string {
+	if id, ok := auth.FromContext(ctx); ok {
+		return "subject:" + id.Subject
+	}
+	return "ip:" + hostOf(remoteAddr)
+}
+
+func hostOf(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func lastForwarded(xff string) string {
+	return strings.TrimSpace(xff[strings.LastIndex(xff, ",")+1:])
+}
+
+// UnaryServerInterceptor 在调用handler之前检查，被限流时通过retry-after告诉客户端等待的秒数
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if seconds, err := l.Check(info.FullMethod, Caller(ctx)); err != nil {
+			_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, strconv.Itoa(seconds)))
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 在建立流的时候检查一次
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if seconds, err := l.Check(info.FullMethod, Caller(ss.Context())); err != nil {
+			_ = ss.SetHeader(metadata.Pairs(RetryAfterHeader, strconv.Itoa(seconds)))
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
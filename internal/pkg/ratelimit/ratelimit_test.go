@@ -0,0 +1,170 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go-grpc/internal/pkg/auth"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 固定的时钟，advance之后才会补充令牌
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestLimiter(t *testing.T, fallback Rule, rules []Rule) (*Limiter, *fakeClock) {
+	t.Helper()
+	l, err := New(fallback, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Unix(1600000000, 0)}
+	l.now = clock.now
+	return l, clock
+}
+
+// 时钟前进advance之后调用calls次，前allowed个调用通过，之后的被拒绝
+type step struct {
+	advance time.Duration
+	calls   int
+	allowed int
+}
+
+func TestWaitBurstAndRefill(t *testing.T) {
+	const method = "/v2.ToDoService/Read"
+	tests := []struct {
+		name  string
+		rule  Rule
+		steps []step
+	}{
+		{
+			name: "突发之后按速率补充",
+			rule: Rule{Rate: 2, Burst: 3},
+			steps: []step{
+				{0, 5, 3},
+				{250 * time.Millisecond, 1, 0},
+				{250 * time.Millisecond, 2, 1},
+				{time.Second, 3, 2},
+				// 空闲很久也最多攒burst个
+				{time.Hour, 5, 3},
+			},
+		},
+		{
+			name: "burst小于1时按1处理",
+			rule: Rule{Rate: 1, Burst: 0},
+			steps: []step{
+				{0, 2, 1},
+				{time.Second, 2, 1},
+			},
+		},
+		{
+			name: "rate不大于0时不限流",
+			rule: Rule{Rate: 0, Burst: 1},
+			steps: []step{
+				{0, 100, 100},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, clock := newTestLimiter(t, tt.rule, nil)
+			for i, step := range tt.steps {
+				clock.advance(step.advance)
+				for j := 0; j < step.calls; j++ {
+					delay := l.Wait(method, "ip:127.0.0.1")
+					if allowed := j < step.allowed; allowed != (delay == 0) {
+						t.Fatalf("第%d步第%d个调用：等待%v，应该通过：%v", i+1, j+1, delay, allowed)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWaitDelay(t *testing.T) {
+	l, clock := newTestLimiter(t, Rule{Rate: 2, Burst: 1}, nil)
+	if d := l.Wait("/m", "a"); d != 0 {
+		t.Fatalf("第一个调用应该通过，等待%v", d)
+	}
+	// 被拒绝的调用不消耗令牌，所以每次返回的等待时间都一样
+	for i := 0; i < 3; i++ {
+		if d := l.Wait("/m", "a"); d != 500*time.Millisecond {
+			t.Fatalf("应该等待500ms，结果是%v", d)
+		}
+	}
+	clock.advance(200 * time.Millisecond)
+	if d := l.Wait("/m", "a"); d != 300*time.Millisecond {
+		t.Fatalf("应该等待300ms，结果是%v", d)
+	}
+}
+
+func TestWaitBuckets(t *testing.T) {
+	l, _ := newTestLimiter(t, Rule{Rate: 1, Burst: 1}, []Rule{
+		{Methods: []string{"/v2.ToDoService/ReadAll", "/v1.ToDoService/ReadAll"}, Rate: 1, Burst: 1},
+	})
+	tests := []struct {
+		name           string
+		method, caller string
+		allowed        bool
+	}{
+		{"第一次调用", "/v2.ToDoService/ReadAll", "a", true},
+		{"同一个桶", "/v2.ToDoService/ReadAll", "a", false},
+		{"同一条规则的方法共用一个桶", "/v1.ToDoService/ReadAll", "a", false},
+		{"每个调用方一个桶", "/v2.ToDoService/ReadAll", "b", true},
+		{"没有匹配规则的方法用默认的桶", "/v2.ToDoService/Read", "a", true},
+		{"没有匹配规则的方法共用默认的桶", "/v2.ToDoService/Create", "a", false},
+	}
+	for _, tt := range tests {
+		if allowed := l.Wait(tt.method, tt.caller) == 0; allowed != tt.allowed {
+			t.Fatalf("%s：应该通过：%v", tt.name, tt.allowed)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	l, _ := newTestLimiter(t, Rule{Rate: 0.25, Burst: 1}, nil)
+	if _, err := l.Check("/m", "a"); err != nil {
+		t.Fatal(err)
+	}
+	seconds, err := l.Check("/m", "a")
+	if status.Code(err) != codes.ResourceExhausted || seconds != 4 {
+		t.Fatalf("应该返回ResourceExhausted和4秒，结果是%v, %v", seconds, err)
+	}
+}
+
+func TestNewInvalidRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{"没有方法", []Rule{{Rate: 1, Burst: 1}}},
+		{"方法格式错误", []Rule{{Methods: []string{"/v2.ToDoService/["}, Rate: 1, Burst: 1}}},
+	}
+	for _, tt := range tests {
+		if _, err := New(Rule{}, tt.rules); err == nil {
+			t.Fatalf("%s：应该返回错误", tt.name)
+		}
+	}
+}
+
+func TestCaller(t *testing.T) {
+	ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "golearner"})
+	if c := Caller(ctx); c != "subject:golearner" {
+		t.Fatalf("通过认证的调用方应该按Subject区分，结果是%s", c)
+	}
+	if c := HTTPCaller(context.Background(), "10.0.0.1:1234"); c != "ip:10.0.0.1" {
+		t.Fatalf("没有认证的调用方应该按IP区分，结果是%s", c)
+	}
+}
@@ -0,0 +1,137 @@
+// rbac 按调用方的角色授权：配置中按方法声明哪些角色可以调用，角色来自JWT的claim或者客户端证书的OU
+// 在auth之后执行，auth负责识别调用方和scope，这里只看角色
+// dry run时只记录会被拒绝的请求，不真正拒绝，用来在上线规则之前检查影响；拒绝的次数累计在rbac_denials_total指标中
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+
+	"go-grpc/internal/pkg/auth"
+	"go-grpc/internal/pkg/errs"
+	"go-grpc/internal/pkg/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// AnyRole 匹配所有通过认证的调用方，包括没有角色的API key
+const AnyRole = "*"
+
+// Denials 按方法统计的拒绝次数，dry_run为true的是没有真正拒绝的
+var Denials = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "rbac_denials_total",
+	Help: "Total number of calls denied by the RBAC policy.",
+}, []string{"method", "dry_run"})
+
+// Rule 允许Roles中的角色调用Methods中的方法
+// 方法是gRPC的FullMethod，支持path.Match的通配符，比如/v2.ToDoService/*、/*/Delete
+type Rule struct {
+	Methods []string
+	Roles   []string
+}
+
+// Policy 按顺序匹配规则，第一个匹配方法的规则决定是否允许；没有规则匹配时按defaultAllow
+type Policy struct {
+	rules        []Rule
+	defaultAllow bool
+	dryRun       bool
+}
+
+// NewPolicy 创建Policy，方法的模式有错误时返回错误
+func NewPolicy(rules []Rule, defaultAllow, dryRun bool) (*Policy, error) {
+	for i, r := range rules {
+		if len(r.Methods) == 0 || len(r.Roles) == 0 {
+			return nil, fmt.Errorf("第%d条规则的methods和roles不能为空", i+1)
+		}
+		for _, m := range r.Methods {
+			if _, err := path.Match(m, ""); err != nil {
+				return nil, fmt.Errorf("第%d条规则的方法%s格式错误：%v", i+1, m, err)
+			}
+		}
+	}
+	return &Policy{rules: rules, defaultAllow: defaultAllow, dryRun: dryRun}, nil
+}
+
+// 返回第一个匹配方法的规则
+func (p *Policy) match(method string) (*Rule, bool) {
+	for i := range p.rules {
+		for _, m := range p.rules[i].Methods {
+			if ok, _ := path.Match(m, method); ok {
+				return &p.rules[i], true
+			}
+		}
+	}
+	return nil, false
+}
+
+func allows(rule *Rule, id *auth.Identity) bool {
+	for _, want := range rule.Roles {
+		if want == AnyRole {
+			return true
+		}
+		for _, role := range id.Roles {
+			if role == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorize 检查当前调用方能不能调用method，dry run时总是返回nil
+func (p *Policy) Authorize(ctx context.Context, method string) error {
+	rule, ok := p.match(method)
+	if !ok {
+		if p.defaultAllow {
+			return nil
+		}
+		return p.deny(ctx, method, errs.New(codes.PermissionDenied, errs.ReasonPermissionDenied, "没有调用"+method+"的权限", "method", method))
+	}
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return p.deny(ctx, method, errs.New(codes.Unauthenticated, errs.ReasonUnauthenticated, "缺少认证信息"))
+	}
+	if !allows(rule, id) {
+		return p.deny(ctx, method, errs.New(codes.PermissionDenied, errs.ReasonPermissionDenied, "没有调用"+method+"的权限", "method", method))
+	}
+	return nil
+}
+
+func (p *Policy) deny(ctx context.Context, method string, err error) error {
+	Denials.WithLabelValues(method, strconv.FormatBool(p.dryRun)).Inc()
+	if !p.dryRun {
+		return err
+	}
+	fields := []zap.Field{zap.String("method", method), zap.Error(err)}
+	if id, ok := auth.FromContext(ctx); ok {
+		fields = append(fields, zap.String("subject", id.Subject), zap.Strings("roles", id.Roles))
+	}
+	logging.FromContext(ctx).Warn("RBAC dry run：请求会被拒绝", fields...)
+	return nil
+}
+
+// UnaryServerInterceptor 在调用handler之前授权
+func (p *Policy) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := p.Authorize(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 在建立流的时候授权一次
+func (p *Policy) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := p.Authorize(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,125 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+
+	"go-grpc/internal/pkg/auth"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func withRoles(roles ...string) context.Context {
+	return auth.NewContext(context.Background(), &auth.Identity{Subject: "golearner", Roles: roles})
+}
+
+func TestAuthorize(t *testing.T) {
+	rules := []Rule{
+		// 第一个匹配的规则生效，后面更宽松的规则不会再看
+		{Methods: []string{"/v2.ToDoService/Delete"}, Roles: []string{"admin"}},
+		{Methods: []string{"/v2.ToDoService/*"}, Roles: []string{"editor", "admin"}},
+		{Methods: []string{"/*/Read", "/*/List"}, Roles: []string{AnyRole}},
+	}
+	tests := []struct {
+		name         string
+		defaultAllow bool
+		ctx          context.Context
+		method       string
+		want         codes.Code
+	}{
+		{"第一条规则允许", false, withRoles("admin"), "/v2.ToDoService/Delete", codes.OK},
+		{"第一条规则拒绝，不看后面的规则", false, withRoles("editor"), "/v2.ToDoService/Delete", codes.PermissionDenied},
+		{"通配符匹配方法", false, withRoles("editor"), "/v2.ToDoService/Update", codes.OK},
+		{"通配符匹配但角色不对", false, withRoles("viewer"), "/v2.ToDoService/Update", codes.PermissionDenied},
+		{"多个角色中有一个匹配", false, withRoles("viewer", "editor"), "/v2.ToDoService/Create", codes.OK},
+		{"AnyRole允许没有角色的调用方", false, withRoles(), "/v1.ToDoService/Read", codes.OK},
+		{"AnyRole也要求认证", false, context.Background(), "/v1.ToDoService/Read", codes.Unauthenticated},
+		{"没有认证信息", false, context.Background(), "/v2.ToDoService/Update", codes.Unauthenticated},
+		{"没有匹配的规则时默认允许", true, context.Background(), "/v2.APIKeyService/Create", codes.OK},
+		{"没有匹配的规则时默认拒绝", false, withRoles("admin"), "/v2.APIKeyService/Create", codes.PermissionDenied},
+		{"通配符不跨越/", false, withRoles("viewer"), "/v2.ToDoService/Read/x", codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy(rules, tt.defaultAllow, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := status.Code(p.Authorize(tt.ctx, tt.method)); got != tt.want {
+				t.Fatalf("Authorize(%s) = %v，应该是%v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	rules := []Rule{{Methods: []string{"/v2.ToDoService/Delete"}, Roles: []string{"admin"}}}
+	p, err := NewPolicy(rules, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		denied bool
+	}{
+		{"角色不对", withRoles("viewer"), "/v2.ToDoService/Delete", true},
+		{"没有认证信息", context.Background(), "/v2.ToDoService/Delete", true},
+		{"没有匹配的规则", withRoles("viewer"), "/v2.ToDoService/Create", true},
+		{"允许", withRoles("admin"), "/v2.ToDoService/Delete", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dry := Denials.WithLabelValues(tt.method, "true")
+			enforced := Denials.WithLabelValues(tt.method, "false")
+			beforeDry, beforeEnforced := testutil.ToFloat64(dry), testutil.ToFloat64(enforced)
+			if err := p.Authorize(tt.ctx, tt.method); err != nil {
+				t.Fatalf("dry run时应该返回nil，结果是%v", err)
+			}
+			want := beforeDry
+			if tt.denied {
+				want++
+			}
+			if got := testutil.ToFloat64(dry); got != want {
+				t.Fatalf("rbac_denials_total{dry_run=true}应该是%v，结果是%v", want, got)
+			}
+			if got := testutil.ToFloat64(enforced); got != beforeEnforced {
+				t.Fatalf("dry run不应该增加dry_run=false的计数，结果是%v", got)
+			}
+		})
+	}
+}
+
+func TestEnforcedDenialCounted(t *testing.T) {
+	p, err := NewPolicy([]Rule{{Methods: []string{"/v2.ToDoService/Delete"}, Roles: []string{"admin"}}}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Denials.WithLabelValues("/v2.ToDoService/Delete", "false")
+	before := testutil.ToFloat64(c)
+	if err := p.Authorize(withRoles("viewer"), "/v2.ToDoService/Delete"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("应该返回PermissionDenied，结果是%v", err)
+	}
+	if got := testutil.ToFloat64(c); got != before+1 {
+		t.Fatalf("拒绝之后rbac_denials_total应该加1，结果是%v", got-before)
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{"没有方法", []Rule{{Roles: []string{"admin"}}}},
+		{"没有角色", []Rule{{Methods: []string{"/*"}}}},
+		{"模式错误", []Rule{{Methods: []string{"/v2.ToDoService/["}, Roles: []string{"admin"}}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewPolicy(tt.rules, false, false); err == nil {
+			t.Fatalf("%s：应该返回错误", tt.name)
+		}
+	}
+}
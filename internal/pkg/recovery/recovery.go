@@ -0,0 +1,64 @@
+// recovery 把处理请求时发生的panic转换成codes.Internal错误，避免一个请求的bug导致整个进程退出
+// panic的堆栈只记录在服务端日志中，次数按来源（grpc/http）累计在panics_recovered_total指标中
+package recovery
+
+import (
+	"context"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go-grpc/internal/pkg/errs"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// 来源的名字，也是Panics的source标签
+const (
+	SourceGRPC = "grpc"
+	SourceHTTP = "http"
+)
+
+// Panics 按来源统计的panic次数
+var Panics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "panics_recovered_total",
+	Help: "Total number of panics recovered while handling requests.",
+}, []string{"source"})
+
+// Recovered 记录recover()得到的p和当前的堆栈，返回给客户端的错误不包含panic的内容
+// 必须在defer的函数中调用，where是请求的方法名或者路径
+func Recovered(source, where string, p interface{}) error {
+	Panics.WithLabelValues(source).Inc()
+	zap.L().Error("处理请求时发生panic",
+		zap.String("source", source),
+		zap.String("method", where),
+		zap.Any("panic", p),
+		zap.ByteString("stack", debug.Stack()),
+	)
+	return errs.New(codes.Internal, errs.ReasonInternal, "服务内部错误")
+}
+
+// UnaryServerInterceptor 把handler中的panic转换成错误
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (res interface{}, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				res, err = nil, Recovered(SourceGRPC, info.FullMethod, p)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 把流式handler中的panic转换成错误
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = Recovered(SourceGRPC, info.FullMethod, p)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
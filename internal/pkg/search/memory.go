@@ -0,0 +1,108 @@
+package search
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	v2 "go-grpc/api/server/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// title命中的权重比description高
+const titleWeight = 2
+
+// MemoryIndex 是进程内的倒排索引，用于不支持全文索引的后端，启动时需要把所有ToDo都Index一遍
+type MemoryIndex struct {
+	mu   sync.RWMutex
+	docs map[int64]*v2.ToDo
+	// 词 -> ToDo ID -> 加权后的词频
+	postings map[string]map[int64]int
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     make(map[int64]*v2.ToDo),
+		postings: make(map[string]map[int64]int),
+	}
+}
+
+func (m *MemoryIndex) Index(td *v2.ToDo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(td.Id)
+	m.docs[td.Id] = proto.Clone(td).(*v2.ToDo)
+	add := func(text string, weight int) {
+		for _, t := range Tokenize(text) {
+			p, ok := m.postings[t]
+			if !ok {
+				p = make(map[int64]int)
+				m.postings[t] = p
+			}
+			p[td.Id] += weight
+		}
+	}
+	add(td.Title, titleWeight)
+	add(td.Description, 1)
+}
+
+func (m *MemoryIndex) Remove(id int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+}
+
+func (m *MemoryIndex) remove(id int64) {
+	if _, ok := m.docs[id]; !ok {
+		return
+	}
+	delete(m.docs, id)
+	for t, p := range m.postings {
+		delete(p, id)
+		if len(p) == 0 {
+			delete(m.postings, t)
+		}
+	}
+}
+
+// 使用TF-IDF打分，任意一个词命中就算命中，和MySQL的NATURAL LANGUAGE MODE保持一致
+func (m *MemoryIndex) Search(ctx context.Context, q string, offset, limit int) ([]Hit, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	scores := make(map[int64]float64)
+	n := float64(len(m.docs))
+	for _, t := range Tokenize(q) {
+		p := m.postings[t]
+		if len(p) == 0 {
+			continue
+		}
+		idf := math.Log(1 + n/float64(len(p)))
+		for id, tf := range p {
+			scores[id] += float64(tf) * idf
+		}
+	}
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ToDo: m.docs[id], Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ToDo.Id < hits[j].ToDo.Id
+	})
+	total := len(hits)
+	if offset >= total {
+		return nil, total, nil
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	page := make([]Hit, 0, end-offset)
+	for _, h := range hits[offset:end] {
+		page = append(page, Hit{ToDo: proto.Clone(h.ToDo).(*v2.ToDo), Score: h.Score})
+	}
+	return page, total, nil
+}
@@ -0,0 +1,59 @@
+package search
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	v2 "go-grpc/api/server/v2"
+)
+
+// MySQLEngine 使用ToDo表上的FULLTEXT索引（见schema.sql），索引由MySQL自己维护
+type MySQLEngine struct {
+	db *sql.DB
+}
+
+func NewMySQLEngine(db *sql.DB) *MySQLEngine {
+	return &MySQLEngine{db: db}
+}
+
+const matchClause = "MATCH(`Title`, `Description`) AGAINST(? IN NATURAL LANGUAGE MODE)"
+
+func (e *MySQLEngine) Search(ctx context.Context, q string, offset, limit int) ([]Hit, int, error) {
+	var total int
+	if err := e.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ToDo WHERE "+matchClause, q).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if total == 0 || offset >= total {
+		return nil, total, nil
+	}
+	rows, err := e.db.QueryContext(ctx, "SELECT `ID`, `Title`, `Description`, `Reminder`, "+matchClause+" AS `Score` FROM ToDo WHERE "+matchClause+" ORDER BY `Score` DESC, `ID` LIMIT ? OFFSET ?",
+		q, q, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var hits []Hit
+	var reminder time.Time
+	for rows.Next() {
+		td := new(v2.ToDo)
+		var score float64
+		if err := rows.Scan(&td.Id, &td.Title, &td.Description, &reminder, &score); err != nil {
+			return nil, 0, err
+		}
+		td.Reminder, err = ptypes.TimestampProto(reminder)
+		if err != nil {
+			return nil, 0, err
+		}
+		hits = append(hits, Hit{ToDo: td, Score: score, Stored: true})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return hits, total, nil
+}
+
+func (e *MySQLEngine) Index(td *v2.ToDo) {}
+
+func (e *MySQLEngine) Remove(id int64) {}
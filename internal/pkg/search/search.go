@@ -0,0 +1,169 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	v2 "go-grpc/api/server/v2"
+)
+
+// Hit是一条搜索结果
+type Hit struct {
+	ToDo  *v2.ToDo
+	Score float64
+	// 为true时ToDo是直接从表中读出的，description是保存的形式，可能是加密的
+	Stored bool
+}
+
+// Engine是全文搜索的抽象，MySQL使用FULLTEXT索引，其他后端使用进程内的倒排索引
+type Engine interface {
+	// 按相关度从高到低返回[offset, offset+limit)区间的结果，以及命中的总数
+	Search(ctx context.Context, q string, offset, limit int) ([]Hit, int, error)
+	// ToDo新增或者修改后调用，由数据库维护索引的实现可以忽略
+	Index(td *v2.ToDo)
+	// ToDo删除后调用
+	Remove(id int64)
+}
+
+// 判断是否是中日韩文字，这些文字之间没有空格，需要按字切分
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
+
+// Tokenize 把文本切分成小写的词，英文按非字母数字切分，中文每个字是一个词
+func Tokenize(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(unicode.ToLower(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+const (
+	snippetContext = 30
+	highlightOpen  = "<em>"
+	highlightClose = "</em>"
+)
+
+// Highlight 在text中找到第一个命中的关键字，截取它前后的一段文字，并且把所有命中的关键字用<em></em>包起来
+// 没有命中时返回空字符串
+func Highlight(text string, terms []string) string {
+	if len(terms) == 0 || len(text) == 0 {
+		return ""
+	}
+	// 直接在text上按字符比较，不能先对整个text做ToLower：有些字符小写之后字节数会变，下标就对不上原文了
+	type span struct{ start, end int }
+	var spans []span
+	for i := 0; i < len(text); {
+		matched := 0
+		for _, t := range terms {
+			if n := matchFold(text[i:], t); n > matched && isBoundary(text, i, i+n) {
+				matched = n
+			}
+		}
+		if matched > 0 {
+			// 相邻的命中合并成一段，避免中文逐字高亮
+			if n := len(spans); n > 0 && spans[n-1].end == i {
+				spans[n-1].end = i + matched
+			} else {
+				spans = append(spans, span{i, i + matched})
+			}
+			i += matched
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	if len(spans) == 0 {
+		return ""
+	}
+	// 以第一个命中为中心截取片段，保证不截断多字节字符
+	from := backRunes(text, spans[0].start, snippetContext)
+	to := forwardRunes(text, spans[0].end, snippetContext)
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("...")
+	}
+	pos := from
+	for _, sp := range spans {
+		if sp.start < from || sp.end > to {
+			continue
+		}
+		b.WriteString(text[pos:sp.start])
+		b.WriteString(highlightOpen)
+		b.WriteString(text[sp.start:sp.end])
+		b.WriteString(highlightClose)
+		pos = sp.end
+	}
+	b.WriteString(text[pos:to])
+	if to < len(text) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+// 判断s是否以关键字term开头，term是Tokenize的结果，按Tokenize的方式逐个字符转成小写比较
+// 返回匹配部分在s中的字节数，不匹配时返回0
+func matchFold(s, term string) int {
+	i := 0
+	for _, tr := range term {
+		if i >= len(s) {
+			return 0
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if unicode.ToLower(r) != tr {
+			return 0
+		}
+		i += size
+	}
+	return i
+}
+
+// 英文关键字要求整词匹配，中文不需要
+func isBoundary(s string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(s[:start])
+	after, _ := utf8.DecodeRuneInString(s[end:])
+	first, _ := utf8.DecodeRuneInString(s[start:])
+	if isCJK(first) {
+		return true
+	}
+	word := func(r rune) bool {
+		return r != utf8.RuneError && !isCJK(r) && (unicode.IsLetter(r) || unicode.IsDigit(r))
+	}
+	return !word(before) && !word(after)
+}
+
+func backRunes(s string, i, n int) int {
+	for ; n > 0 && i > 0; n-- {
+		_, size := utf8.DecodeLastRuneInString(s[:i])
+		i -= size
+	}
+	return i
+}
+
+func forwardRunes(s string, i, n int) int {
+	for ; n > 0 && i < len(s); n-- {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+	return i
+}
@@ -0,0 +1,115 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	v2 "go-grpc/api/server/v2"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Buy MILK, eggs", []string{"buy", "milk", "eggs"}},
+		{"买牛奶", []string{"买", "牛", "奶"}},
+		{"go语言2024", []string{"go", "语", "言", "2024"}},
+		{"İstanbul", []string{"istanbul"}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("Tokenize(%q) = %q，应该是%q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	before, after := strings.Repeat("x ", 40), strings.Repeat(" x", 40)
+	tests := []struct {
+		name string
+		text string
+		q    string
+		want string
+	}{
+		{"整词匹配", "Buy milk and eggs", "MILK", "Buy <em>milk</em> and eggs"},
+		{"保留原文的大小写", "Buy MILK", "milk", "Buy <em>MILK</em>"},
+		{"不匹配词的一部分", "milkshake", "milk", ""},
+		{"多个关键字", "milk and eggs", "eggs milk", "<em>milk</em> and <em>eggs</em>"},
+		{"相邻的中文合并", "今天去买牛奶", "牛奶", "今天去买<em>牛奶</em>"},
+		// 小写之后UTF-8的字节数会变的字符，原来按小写之后的下标截取原文会越界或者截断字符
+		{"小写之后变长", "ȺȺȺȺ abc", "abc", "ȺȺȺȺ <em>abc</em>"},
+		{"小写之后变长的字符本身命中", "ȺȺȺȺ abc", "ⱥⱥⱥⱥ", "<em>ȺȺȺȺ</em> abc"},
+		{"小写之后变短", "\u212A\u212A abc", "abc", "\u212A\u212A <em>abc</em>"},
+		{"小写之后变短的字符本身命中", "\u212Aelvin", "kelvin", "<em>\u212Aelvin</em>"},
+		// strings.ToLower会把İ变成两个字符
+		{"变成两个字符", "İİİİ abc", "abc", "İİİİ <em>abc</em>"},
+		{"变成两个字符的字符本身命中", "İstanbul trip", "istanbul", "<em>İstanbul</em> trip"},
+		{"截取片段", before + "milk" + after, "milk", "..." + before[len(before)-30:] + "<em>milk</em>" + after[:30] + "..."},
+		{"没有命中", "Buy milk", "eggs", ""},
+		{"空文本", "", "milk", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Highlight(tt.text, Tokenize(tt.q))
+			if got != tt.want {
+				t.Fatalf("Highlight(%q, %q) = %q，应该是%q", tt.text, tt.q, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Fatalf("结果不是合法的UTF-8：%q", got)
+			}
+		})
+	}
+}
+
+func TestMemoryIndex(t *testing.T) {
+	m := NewMemoryIndex()
+	m.Index(&v2.ToDo{Id: 1, Title: "买牛奶", Description: "去超市"})
+	m.Index(&v2.ToDo{Id: 2, Title: "写周报", Description: "记得买咖啡"})
+	m.Index(&v2.ToDo{Id: 3, Title: "Buy milk", Description: "milk milk"})
+	ids := func(hits []Hit) []int64 {
+		var out []int64
+		for _, h := range hits {
+			out = append(out, h.ToDo.Id)
+		}
+		return out
+	}
+	tests := []struct {
+		name          string
+		q             string
+		offset, limit int
+		want          []int64
+		total         int
+	}{
+		{"title的权重更高", "买", 0, 10, []int64{1, 2}, 2},
+		{"不区分大小写", "MILK", 0, 10, []int64{3}, 1},
+		{"分页", "买", 1, 1, []int64{2}, 2},
+		{"超出范围", "买", 5, 1, nil, 2},
+		{"没有命中", "eggs", 0, 10, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits, total, err := m.Search(context.Background(), tt.q, tt.offset, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(hits); !reflect.DeepEqual(got, tt.want) || total != tt.total {
+				t.Fatalf("Search(%q) = %v, %d，应该是%v, %d", tt.q, got, total, tt.want, tt.total)
+			}
+		})
+	}
+
+	// 修改之后旧的词不再命中，删除之后完全不命中
+	m.Index(&v2.ToDo{Id: 1, Title: "买面包"})
+	if hits, _, _ := m.Search(context.Background(), "牛奶", 0, 10); len(hits) != 0 {
+		t.Fatalf("修改之后不应该再命中旧的title：%v", ids(hits))
+	}
+	m.Remove(2)
+	if hits, _, _ := m.Search(context.Background(), "买", 0, 10); !reflect.DeepEqual(ids(hits), []int64{1}) {
+		t.Fatalf("删除之后不应该再命中：%v", ids(hits))
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	v1 "go-grpc/api/server/v1"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/errs"
+	service "go-grpc/internal/service/server/v1"
+	servicev2 "go-grpc/internal/service/server/v2"
+	"google.golang.org/grpc/codes"
+)
+
+// 附件的HTTP接口，gateway不支持multipart和Range，所以直接注册在http的mux上
+// POST /{v1|v2}/attachments?toDoId={id}  multipart上传，文件放在file字段
+// GET  /{v1|v2}/attachments/{id}          下载，支持Range请求
+// 两个版本共用v2的实现，只有上传的响应格式不同
+func registerAttachmentHandler(mux *http.ServeMux, svc *servicev2.ToDoServiceServer) {
+	handlers := []*attachmentHandler{
+		{svc: svc, prefix: "/v1/attachments", service: v1.ToDoService_ServiceDesc.ServiceName, respond: func(a *v2.Attachment) interface{} {
+			return &v1.UploadAttachmentResponse{Api: "v1", Attachment: service.ToV1Attachment(a)}
+		}},
+		{svc: svc, prefix: "/v2/attachments", service: v2.ToDoService_ServiceDesc.ServiceName, respond: func(a *v2.Attachment) interface{} {
+			return &v2.UploadAttachmentResponse{Attachment: a}
+		}},
+	}
+	for _, h := range handlers {
+		methods := map[string]string{
+			http.MethodPost: fullMethod(h.service, "UploadAttachment"),
+			http.MethodGet:  fullMethod(h.service, "DownloadAttachment"),
+			http.MethodHead: fullMethod(h.service, "DownloadAttachment"),
+		}
+		mux.Handle(h.prefix, protectHTTP(methods, h))
+		mux.Handle(h.prefix+"/", protectHTTP(methods, h))
+	}
+}
+
+type attachmentHandler struct {
+	svc    *servicev2.ToDoServiceServer
+	prefix string
+	// 对应的gRPC服务，按它的方法授权
+	service string
+	// 生成上传成功后的响应
+	respond func(a *v2.Attachment) interface{}
+}
+
+func (h *attachmentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == h.prefix && r.Method == http.MethodPost:
+		h.upload(w, r)
+	case strings.HasPrefix(r.URL.Path, h.prefix+"/") && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		h.download(w, r)
+	default:
+		writeStatusError(w, r, errs.New(codes.Unimplemented, errs.ReasonUnsupportedRequest, fmt.Sprintf("不支持的请求：%s %s", r.Method, r.URL.Path)))
+	}
+}
+
+func (h *attachmentHandler) upload(w http.ResponseWriter, r *http.Request) {
+	toDoID, err := strconv.ParseInt(r.URL.Query().Get("toDoId"), 10, 64)
+	if err != nil {
+		writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, "toDoId参数无效", "field", "toDoId"))
+		return
+	}
+	// 用MultipartReader流式读取，不把整个文件读进内存
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, "请求不是multipart格式："+err.Error()))
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, "找不到file字段", "field", "file"))
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+		contentType := part.Header.Get("Content-Type")
+		a, err := h.svc.SaveAttachment(r.Context(), toDoID, part.FileName(), contentType, part)
+		part.Close()
+		if err != nil {
+			writeStatusError(w, r, err)
+			return
+		}
+		writeMessage(w, http.StatusOK, h.respond(a))
+		return
+	}
+}
+
+func (h *attachmentHandler) download(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, h.prefix+"/"), 10, 64)
+	if err != nil {
+		writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, "附件ID无效", "field", "id"))
+		return
+	}
+	a, obj, err := h.svc.OpenAttachment(r.Context(), id)
+	if err != nil {
+		writeStatusError(w, r, err)
+		return
+	}
+	defer obj.Close()
+	// Content-Type是上传方填的，可能是text/html，和API同源返回时浏览器会执行里面的脚本
+	// 所以总是作为下载返回，禁止浏览器猜测类型，并且用沙箱的CSP兜底；没有类型时不让ServeContent去猜
+	contentType := a.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	// ServeContent会处理Range、If-Modified-Since等请求头
+	http.ServeContent(w, r, a.Name, obj.ModTime(), obj)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go-grpc/internal/pkg/audit"
+)
+
+// 开启审计时在启动时打开，gRPC的拦截器和直接注册在mux上的HTTP接口写同一个文件
+var auditLog *audit.Log
+
+func newAuditLog() (*audit.Log, error) {
+	if !cfg.Audit.Enabled {
+		return nil, nil
+	}
+	if len(cfg.Audit.Path) == 0 {
+		return nil, fmt.Errorf("审计日志需要配置path")
+	}
+	return audit.Open(audit.Options{Path: cfg.Audit.Path, Key: []byte(cfg.Audit.Key), Methods: cfg.Audit.Methods})
+}
+
+// 记录HTTP接口的状态码，作为审计日志中调用的结果
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *auditRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *auditRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *auditRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *auditRecorder) code() string {
+	if r.status == 0 {
+		return strconv.Itoa(http.StatusOK)
+	}
+	return strconv.Itoa(r.status)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	v1 "go-grpc/api/server/v1"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/auth"
+	"go-grpc/internal/pkg/ratelimit"
+	"go-grpc/internal/pkg/rbac"
+
+	"google.golang.org/grpc"
+)
+
+// 开启认证时在启动时创建，gRPC的拦截器和直接注册在mux上的HTTP接口共用
+var guard *auth.Guard
+
+// 开启RBAC时在启动时创建，同样由gRPC的拦截器和HTTP接口共用
+var policy *rbac.Policy
+
+// 会修改数据的ToDo接口需要todo.write，其他的需要todo.read
+var toDoWriteMethods = map[string]bool{
+	"Create":           true,
+	"Update":           true,
+	"Delete":           true,
+	"UploadAttachment": true,
+	"Import":           true,
+}
+
+// gRPC的FullMethod，比如/v2.ToDoService/Delete
+func fullMethod(service, method string) string {
+	return "/" + service + "/" + method
+}
+
+// 每个gRPC方法需要的scope
+func methodScopes() map[string]string {
+	scopes := make(map[string]string)
+	add := func(desc grpc.ServiceDesc, scopeOf func(method string) string) {
+		for _, m := range desc.Methods {
+			scopes[fullMethod(desc.ServiceName, m.MethodName)] = scopeOf(m.MethodName)
+		}
+		for _, s := range desc.Streams {
+			scopes[fullMethod(desc.ServiceName, s.StreamName)] = scopeOf(s.StreamName)
+		}
+	}
+	toDoScope := func(method string) string {
+		if toDoWriteMethods[method] {
+			return auth.ScopeToDoWrite
+		}
+		return auth.ScopeToDoRead
+	}
+	add(v1.ToDoService_ServiceDesc, toDoScope)
+	add(v2.ToDoService_ServiceDesc, toDoScope)
+	add(v2.APIKeyService_ServiceDesc, func(string) string { return auth.ScopeAPIKeyAdmin })
+	return scopes
+}
+
+func newAuthGuard(keys auth.APIKeyStore) (*auth.Guard, error) {
+	if !cfg.Auth.Enabled {
+		return nil, nil
+	}
+	var authenticators []auth.Authenticator
+	// 没有配置JWT的密钥时只使用API key
+	if len(cfg.Auth.JWT.JWKS) > 0 || len(cfg.Auth.JWT.HMACSecrets) > 0 {
+		// JWKS文件和TLS证书一样，是相对于项目根目录的
+		jwks := make([]string, 0, len(cfg.Auth.JWT.JWKS))
+		for _, p := range cfg.Auth.JWT.JWKS {
+			jwks = append(jwks, filepath.Join(BaseDir, "../../", p))
+		}
+		jwtAuth, err := auth.NewJWTAuthenticator(auth.JWTOptions{
+			Issuer:        cfg.Auth.JWT.Issuer,
+			Audience:      cfg.Auth.JWT.Audience,
+			JWKSFiles:     jwks,
+			HMACSecrets:   cfg.Auth.JWT.HMACSecrets,
+			Leeway:        cfg.Auth.JWT.Leeway,
+			RolesClaim:    cfg.Auth.JWT.RolesClaim,
+			DefaultScopes: cfg.Auth.JWT.DefaultScopes,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwtAuth)
+	}
+	authenticators = append(authenticators, auth.NewAPIKeyAuthenticator(keys))
+	// 客户端证书排在最后，同时带了token或者API key时以它们为准
+	if clientAuthEnabled() {
+		authenticators = append(authenticators, auth.NewCertAuthenticator(certManager.Loaded, cfg.Auth.Cert.Scopes))
+	}
+	return auth.NewGuard(cfg.Auth.Public, methodScopes(), authenticators...), nil
+}
+
+func newPolicy() (*rbac.Policy, error) {
+	if !cfg.RBAC.Enabled {
+		return nil, nil
+	}
+	if guard == nil {
+		return nil, fmt.Errorf("RBAC需要开启认证")
+	}
+	var defaultAllow bool
+	switch cfg.RBAC.Default {
+	case "", "allow":
+		defaultAllow = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("不支持的RBAC默认策略：%s，可用的有allow、deny", cfg.RBAC.Default)
+	}
+	rules := make([]rbac.Rule, 0, len(cfg.RBAC.Rules))
+	for _, r := range cfg.RBAC.Rules {
+		rules = append(rules, rbac.Rule{Methods: r.Methods, Roles: r.Roles})
+	}
+	return rbac.NewPolicy(rules, defaultAllow, cfg.RBAC.DryRun)
+}
+
+// 附件、导入导出这些不经过gateway的HTTP接口，在这里限制并发、认证、审计、授权和限流，顺序和gRPC的拦截器链一致
+// methods是HTTP方法对应的gRPC方法，按gRPC方法的scope、限流和RBAC规则处理，这样两种调用方式的限制是一致的
+func protectHTTP(methods map[string]string, h http.Handler) http.Handler {
+	if guard == nil && limiter == nil && shedder == nil && auditLog == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		// 上传下载的时间取决于文件的大小，只占用位置，不参与调整限制
+		if method, ok := methods[r.Method]; ok && shedder != nil && !shedder.Exempt(method) {
+			release, err := shedder.Acquire(method)
+			if err != nil {
+				writeStatusError(w, r, err)
+				return
+			}
+			defer release(nil, false)
+		}
+		if guard != nil {
+			var err error
+			if ctx, err = guard.AuthenticateHTTP(r); err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeStatusError(w, r, err)
+				return
+			}
+		}
+		// 没有对应的gRPC方法时由handler返回不支持的请求
+		if method, ok := methods[r.Method]; ok {
+			if guard != nil {
+				if err := guard.AuthorizeMethod(ctx, method); err != nil {
+					writeStatusError(w, r, err)
+					return
+				}
+			}
+			if auditLog != nil {
+				var finish func(string)
+				ctx, finish = auditLog.Begin(ctx, method)
+				rec := &auditRecorder{ResponseWriter: w}
+				w = rec
+				defer func() {
+					// handler panic时还没有写出状态码，按500记录，然后交给net/http处理
+					if r := recover(); r != nil {
+						finish(strconv.Itoa(http.StatusInternalServerError))
+						panic(r)
+					}
+					finish(rec.code())
+				}()
+			}
+			if limiter != nil {
+				if seconds, err := limiter.Check(method, ratelimit.HTTPCaller(ctx, r.RemoteAddr)); err != nil {
+					w.Header().Set("Retry-After", strconv.Itoa(seconds))
+					writeStatusError(w, r, err)
+					return
+				}
+			}
+			if policy != nil {
+				if err := policy.Authorize(ctx, method); err != nil {
+					writeStatusError(w, r, err)
+					return
+				}
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go-grpc/internal/pkg/errs"
+	service "go-grpc/internal/service/server/v2"
+	"google.golang.org/grpc/codes"
+)
+
+const calendarPrefix = "/v1/calendar/"
+
+// 只读的iCalendar订阅地址：GET /v1/calendar/{user}.ics?token={token}
+// 日历应用没法带Authorization头，所以用配置里每个用户的token来保护订阅地址
+// 订阅中只有这个用户创建的ToDo，user中的/和:等字符需要URL编码
+func registerCalendarHandler(mux *http.ServeMux, svc *service.ToDoServiceServer) {
+	tokens := make(map[string]string)
+	for _, f := range cfg.Calendar.Feeds {
+		tokens[f.User] = f.Token
+	}
+	mux.HandleFunc(calendarPrefix, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeStatusError(w, r, errs.New(codes.Unimplemented, errs.ReasonUnsupportedRequest, "订阅地址只支持GET"))
+			return
+		}
+		user, ok := calendarUser(r.URL)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if !calendarTokenValid(tokens[user], r.URL.Query().Get("token")) {
+			writeStatusError(w, r, errs.New(codes.PermissionDenied, errs.ReasonInvalidCalendarToken, "订阅token无效"))
+			return
+		}
+		body, etag, err := svc.CalendarFeed(r.Context(), user)
+		if err != nil {
+			writeStatusError(w, r, err)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(body)
+	})
+}
+
+// 从/v1/calendar/{user}.ics中取出user，按编码之前的路径切分，这样user中可以有/
+func calendarUser(u *url.URL) (string, bool) {
+	p := strings.TrimPrefix(u.EscapedPath(), calendarPrefix)
+	if !strings.HasSuffix(p, ".ics") {
+		return "", false
+	}
+	user, err := url.PathUnescape(strings.TrimSuffix(p, ".ics"))
+	return user, err == nil && len(user) > 0
+}
+
+// 没有配置订阅的用户expected为空，和token错误返回同样的错误，避免被用来枚举用户
+func calendarTokenValid(expected, token string) bool {
+	return len(expected) > 0 && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
+// If-None-Match可以是*，也可以是逗号分隔的多个ETag，弱校验的W/前缀忽略
+func etagMatch(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
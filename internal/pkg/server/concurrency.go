@@ -0,0 +1,32 @@
+package server
+
+import (
+	"fmt"
+
+	"go-grpc/internal/pkg/concurrency"
+)
+
+// 开启并发限制时在启动时创建，gRPC的拦截器和直接注册在mux上的HTTP接口共用同一个限制
+var shedder *concurrency.Limiter
+
+// 上限默认等于连接池的大小，处理中的请求不会多于可用的数据库连接
+func newShedder() (*concurrency.Limiter, error) {
+	if !cfg.Concurrency.Enabled {
+		return nil, nil
+	}
+	max := cfg.Concurrency.Max
+	if max == 0 {
+		max = cfg.Mysql.MaxOpenConns
+	}
+	if max == 0 {
+		return nil, fmt.Errorf("并发限制需要配置max或者mysql.maxOpenConns")
+	}
+	return concurrency.New(concurrency.Options{
+		Initial:       cfg.Concurrency.Initial,
+		Min:           cfg.Concurrency.Min,
+		Max:           max,
+		LatencyTarget: cfg.Concurrency.LatencyTarget,
+		Backoff:       cfg.Concurrency.Backoff,
+		Exempt:        cfg.Concurrency.Exempt,
+	})
+}
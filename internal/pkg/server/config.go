@@ -1,30 +1,188 @@
 package server
 
 import (
+	"flag"
+	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"flag"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	Server struct {
-		Host string `yaml:"host"`
+		Host  string `yaml:"host"`
 		Proxy string `yaml:"proxy"`
-		TLS struct {
-			Enabled bool `yaml:"enabled"`
+		TLS   struct {
+			Enabled     bool   `yaml:"enabled"`
 			CertKeyPath string `yaml:"certKeyPath"`
 			CertPemPath string `yaml:"certPemPath"`
-			CommonName string `yaml:"commonName"`
+			CommonName  string `yaml:"commonName"`
+			// 客户端证书的验证方式：none、verify（有证书时验证）、require（必须出示证书）
+			ClientAuth string `yaml:"clientAuth"`
+			// 签发客户端证书的CA，PEM格式，可以包含多个证书
+			ClientCAPath string `yaml:"clientCAPath"`
+			// 本地开发时在证书所在的目录自动生成自签名的CA和证书，已有的可用时不会重新生成
+			Dev struct {
+				Enabled bool `yaml:"enabled"`
+				// 服务端证书额外的SAN，host、proxy中的主机名和commonName会自动加上
+				Hosts []string `yaml:"hosts"`
+			} `yaml:"dev"`
 		}
+		Shutdown struct {
+			// 收到信号之后先把就绪状态改为NOT_SERVING，等这么久让探针发现，期间照常处理请求
+			Drain time.Duration `yaml:"drain"`
+			// 关闭监听之后等待处理中的请求结束的最长时间，超过之后直接断开
+			Timeout time.Duration `yaml:"timeout"`
+		} `yaml:"shutdown"`
 	}
 	Mysql struct {
-		Host string `yaml:"host"`
-		User string `yaml:"user"`
+		Host     string `yaml:"host"`
+		User     string `yaml:"user"`
 		Password string `yaml:"password"`
 		DBSchema string `yaml:"dbSchema"`
+		// 连接池的大小，为0时不限制；开启并发限制时默认的上限就是这个值
+		MaxOpenConns int `yaml:"maxOpenConns"`
+		MaxIdleConns int `yaml:"maxIdleConns"`
+	}
+	Attachment struct {
+		Dir string `yaml:"dir"`
+	}
+	Search struct {
+		// mysql使用FULLTEXT索引，memory使用进程内的倒排索引
+		Backend string `yaml:"backend"`
+	}
+	Log struct {
+		// debug、info、warn、error
+		Level string `yaml:"level"`
+		// json或者console，console适合本地开发
+		Format string `yaml:"format"`
+		// 是否记录unary请求和响应的内容，redact中的字段会被隐藏
+		Payload bool     `yaml:"payload"`
+		Redact  []string `yaml:"redact"`
+	}
+	Metrics struct {
+		Enabled bool `yaml:"enabled"`
+		// 默认/metrics
+		Path string `yaml:"path"`
+		// 在这个地址单独监听，比如只对本机或者内网开放的管理端口
+		// 为空时挂在对外的HTTP服务上，和其他接口一样要求认证
+		Addr string `yaml:"addr"`
+	}
+	Tracing struct {
+		Enabled bool `yaml:"enabled"`
+		// otlp或者stdout
+		Exporter string `yaml:"exporter"`
+		// OTLP collector的gRPC地址
+		Endpoint string `yaml:"endpoint"`
+		Insecure bool   `yaml:"insecure"`
+		// 没有上游trace时的采样比例，0到1
+		SampleRatio float64 `yaml:"sampleRatio"`
+		ServiceName string  `yaml:"serviceName"`
+	}
+	Debug struct {
+		// 注册gRPC服务反射，grpcurl、grpcui可以直接发现接口，生产环境不要开启
+		Reflection bool `yaml:"reflection"`
+		// 注册channelz服务，查看连接、调用次数等运行时信息
+		Channelz bool `yaml:"channelz"`
+	}
+	Auth struct {
+		Enabled bool `yaml:"enabled"`
+		// 不需要认证的gRPC方法，按前缀匹配，比如/grpc.health.v1.Health/
+		Public []string `yaml:"public"`
+		JWT    struct {
+			// 为空时不校验
+			Issuer   string `yaml:"issuer"`
+			Audience string `yaml:"audience"`
+			// RS、PS、ES签名的公钥文件，相对于项目根目录
+			JWKS []string `yaml:"jwks"`
+			// HS签名的密钥，可以配置多个用于轮换
+			HMACSecrets []string `yaml:"hmacSecrets"`
+			// 允许的时钟偏差，比如30s
+			Leeway time.Duration `yaml:"leeway"`
+			// 角色所在的claim，默认roles
+			RolesClaim string `yaml:"rolesClaim"`
+			// token中没有scope时授予的scope
+			DefaultScopes []string `yaml:"defaultScopes"`
+		} `yaml:"jwt"`
+		Cert struct {
+			// 客户端证书认证的调用方授予的scope
+			Scopes []string `yaml:"scopes"`
+		} `yaml:"cert"`
+	}
+	RBAC struct {
+		Enabled bool `yaml:"enabled"`
+		// 只记录会被拒绝的请求，不真正拒绝
+		DryRun bool `yaml:"dryRun"`
+		// 没有规则匹配时allow或者deny，默认allow
+		Default string `yaml:"default"`
+		// 按顺序匹配，第一个匹配方法的规则生效
+		Rules []struct {
+			// gRPC的FullMethod，支持*通配符，比如/*/Delete
+			Methods []string `yaml:"methods"`
+			// 允许的角色，*表示所有通过认证的调用方
+			Roles []string `yaml:"roles"`
+		} `yaml:"rules"`
+	} `yaml:"rbac"`
+	RateLimit struct {
+		Enabled bool `yaml:"enabled"`
+		// 没有规则匹配的方法每个调用方每秒的请求数和突发，rate为0时不限流
+		Rate  float64 `yaml:"rate"`
+		Burst int     `yaml:"burst"`
+		// 按顺序匹配，第一个匹配方法的规则生效，同一条规则中的方法共用一个令牌桶
+		Rules []struct {
+			// gRPC的FullMethod，支持*通配符
+			Methods []string `yaml:"methods"`
+			Rate    float64  `yaml:"rate"`
+			Burst   int      `yaml:"burst"`
+		} `yaml:"rules"`
+	} `yaml:"rateLimit"`
+	Concurrency struct {
+		Enabled bool `yaml:"enabled"`
+		// 同时处理的请求数的范围，max为0时使用mysql.maxOpenConns；initial为0时取max的一半
+		Initial int `yaml:"initial"`
+		Min     int `yaml:"min"`
+		Max     int `yaml:"max"`
+		// unary请求的延迟超过这个值时减小限制，比如200ms
+		LatencyTarget time.Duration `yaml:"latencyTarget"`
+		// 减小限制时乘以的系数，0到1之间
+		Backoff float64 `yaml:"backoff"`
+		// 不受限制的gRPC方法，支持*通配符
+		Exempt []string `yaml:"exempt"`
+	}
+	Audit struct {
+		Enabled bool `yaml:"enabled"`
+		// 只追加的日志文件，相对于项目根目录
+		Path string `yaml:"path"`
+		// 计算HMAC的密钥，为空时用SHA-256；校验时需要同样的密钥
+		Key string `yaml:"key"`
+		// 需要记录的gRPC方法，支持*通配符
+		Methods []string `yaml:"methods"`
+	}
+	Encryption struct {
+		// 开启后新写入的description用activeKey加密；关闭时已经加密的值仍然可以用masterKeys解密
+		Enabled   bool   `yaml:"enabled"`
+		ActiveKey string `yaml:"activeKey"`
+		// 所有的主密钥，轮换时加入新的密钥并改activeKey，执行re-encrypt之后才能删除旧的
+		MasterKeys []struct {
+			ID string `yaml:"id"`
+			// base64编码的16、24或者32字节的AES密钥
+			Key string `yaml:"key"`
+		} `yaml:"masterKeys"`
+	}
+	Middleware struct {
+		// 拦截器链的顺序，排在前面的在外层，为空时使用默认的顺序
+		Chain []string `yaml:"chain"`
+	}
+	Calendar struct {
+		// 每个用户一个订阅token，订阅地址是/v1/calendar/{user}.ics?token={token}
+		// user是认证后的Subject，订阅中只有这个用户创建的ToDo
+		Feeds []struct {
+			User  string `yaml:"user"`
+			Token string `yaml:"token"`
+		} `yaml:"feeds"`
 	}
 }
 
@@ -57,22 +215,89 @@ func newConfig() (*Config, error) {
 	// 处理一下TLS默认路径，使其变成一个绝对路径
 	cfg.Server.TLS.CertPemPath = filepath.Join(BaseDir, "../../", cfg.Server.TLS.CertPemPath)
 	cfg.Server.TLS.CertKeyPath = filepath.Join(BaseDir, "../../", cfg.Server.TLS.CertKeyPath)
+	if len(cfg.Server.TLS.ClientCAPath) > 0 {
+		cfg.Server.TLS.ClientCAPath = filepath.Join(BaseDir, "../../", cfg.Server.TLS.ClientCAPath)
+	}
+	if cfg.Server.Shutdown.Timeout <= 0 {
+		cfg.Server.Shutdown.Timeout = 15 * time.Second
+	}
+	if len(cfg.Metrics.Path) == 0 {
+		cfg.Metrics.Path = "/metrics"
+	}
+	if len(cfg.Tracing.ServiceName) == 0 {
+		cfg.Tracing.ServiceName = "go-grpc"
+	}
+	// 附件目录同样是相对于项目根目录的
+	cfg.Attachment.Dir = filepath.Join(BaseDir, "../../", cfg.Attachment.Dir)
+	if len(cfg.Audit.Path) > 0 {
+		cfg.Audit.Path = filepath.Join(BaseDir, "../../", cfg.Audit.Path)
+	}
 	flag.StringVar(&cfg.Server.Host, "endpoint", cfg.Server.Host, "grpc port to bind")
 	flag.StringVar(&cfg.Server.Proxy, "gateway", cfg.Server.Proxy, "grpc gateway port for http to bind")
 	flag.BoolVar(&cfg.Server.TLS.Enabled, "tls-enabled", cfg.Server.TLS.Enabled, "open TLS")
 	flag.StringVar(&cfg.Server.TLS.CertKeyPath, "tls-key-path", cfg.Server.TLS.CertKeyPath, "TLS Key File path")
 	flag.StringVar(&cfg.Server.TLS.CertPemPath, "tls-pem-path", cfg.Server.TLS.CertPemPath, "TLS Pem File path")
 	flag.StringVar(&cfg.Server.TLS.CommonName, "tls-common-name", cfg.Server.TLS.CommonName, "TLS Common Name")
-	flag.StringVar(&cfg.Mysql.Host, "db-host",  cfg.Mysql.Host, "db host")
-	flag.StringVar(&cfg.Mysql.User, "db-user",  cfg.Mysql.User, "db user")
+	flag.StringVar(&cfg.Server.TLS.ClientAuth, "tls-client-auth", cfg.Server.TLS.ClientAuth, "client certificate verification, none, verify or require")
+	flag.StringVar(&cfg.Server.TLS.ClientCAPath, "tls-client-ca-path", cfg.Server.TLS.ClientCAPath, "CA bundle for verifying client certificates")
+	flag.BoolVar(&cfg.Server.TLS.Dev.Enabled, "tls-dev", cfg.Server.TLS.Dev.Enabled, "generate a local CA and certificates for development")
+	flag.DurationVar(&cfg.Server.Shutdown.Drain, "shutdown-drain", cfg.Server.Shutdown.Drain, "how long to report NOT_SERVING before closing listeners")
+	flag.DurationVar(&cfg.Server.Shutdown.Timeout, "shutdown-timeout", cfg.Server.Shutdown.Timeout, "how long to wait for in-flight calls on shutdown")
+	flag.StringVar(&cfg.Mysql.Host, "db-host", cfg.Mysql.Host, "db host")
+	flag.StringVar(&cfg.Mysql.User, "db-user", cfg.Mysql.User, "db user")
 	flag.StringVar(&cfg.Mysql.Password, "db-password", cfg.Mysql.Password, "db password")
 	flag.StringVar(&cfg.Mysql.DBSchema, "db-schema", cfg.Mysql.DBSchema, "db schema")
+	flag.IntVar(&cfg.Mysql.MaxOpenConns, "db-max-open-conns", cfg.Mysql.MaxOpenConns, "maximum number of open db connections, 0 for unlimited")
+	flag.StringVar(&cfg.Attachment.Dir, "attachment-dir", cfg.Attachment.Dir, "attachment blob store dir")
+	flag.StringVar(&cfg.Search.Backend, "search-backend", cfg.Search.Backend, "search backend, mysql or memory")
+	flag.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "log level, debug, info, warn or error")
+	flag.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "log format, json or console")
+	flag.BoolVar(&cfg.Log.Payload, "log-payload", cfg.Log.Payload, "log unary request and response payloads")
+	flag.StringVar(&cfg.Metrics.Addr, "metrics-addr", cfg.Metrics.Addr, "separate address to serve metrics on")
+	flag.BoolVar(&cfg.Tracing.Enabled, "tracing-enabled", cfg.Tracing.Enabled, "enable OpenTelemetry tracing")
+	flag.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "trace exporter, otlp or stdout")
+	flag.StringVar(&cfg.Tracing.Endpoint, "tracing-endpoint", cfg.Tracing.Endpoint, "OTLP collector grpc endpoint")
+	flag.Float64Var(&cfg.Tracing.SampleRatio, "tracing-sample-ratio", cfg.Tracing.SampleRatio, "sample ratio for traces without a parent")
+	flag.BoolVar(&cfg.Debug.Reflection, "grpc-reflection", cfg.Debug.Reflection, "register gRPC server reflection")
+	flag.BoolVar(&cfg.Debug.Channelz, "grpc-channelz", cfg.Debug.Channelz, "register gRPC channelz service")
+	flag.BoolVar(&cfg.Auth.Enabled, "auth-enabled", cfg.Auth.Enabled, "require authentication for gRPC and REST calls")
+	flag.BoolVar(&cfg.RateLimit.Enabled, "ratelimit-enabled", cfg.RateLimit.Enabled, "enable per caller rate limiting")
+	flag.BoolVar(&cfg.Concurrency.Enabled, "concurrency-enabled", cfg.Concurrency.Enabled, "shed calls above the adaptive concurrency limit")
+	flag.BoolVar(&cfg.Audit.Enabled, "audit-enabled", cfg.Audit.Enabled, "write mutating calls to the audit log")
+	flag.StringVar(&cfg.Audit.Path, "audit-path", cfg.Audit.Path, "audit log file path")
+	flag.BoolVar(&cfg.Encryption.Enabled, "encryption-enabled", cfg.Encryption.Enabled, "encrypt todo descriptions at rest")
+	flag.StringVar(&cfg.Encryption.ActiveKey, "encryption-active-key", cfg.Encryption.ActiveKey, "id of the master key used for new values")
+	flag.BoolVar(&cfg.RBAC.Enabled, "rbac-enabled", cfg.RBAC.Enabled, "enforce role based access rules")
+	flag.BoolVar(&cfg.RBAC.DryRun, "rbac-dry-run", cfg.RBAC.DryRun, "only log calls the RBAC rules would deny")
 	flag.Parse()
-	
+	if err := checkCalendarFeeds(&cfg); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
+// 订阅token直接出现在URL中，可以拿到用户的所有ToDo，太短或者还是示例中的值时拒绝启动
+const minCalendarTokenLength = 32
+
+func checkCalendarFeeds(cfg *Config) error {
+	users := make(map[string]bool)
+	for _, f := range cfg.Calendar.Feeds {
+		if len(f.User) == 0 {
+			return fmt.Errorf("日历订阅的user不能为空")
+		}
+		if users[f.User] {
+			return fmt.Errorf("用户%s配置了多个日历订阅", f.User)
+		}
+		users[f.User] = true
+		if len(f.Token) < minCalendarTokenLength || strings.HasPrefix(f.Token, "change-me") {
+			return fmt.Errorf("用户%s的日历订阅token无效，至少需要%d个随机字符", f.User, minCalendarTokenLength)
+		}
+	}
+	return nil
+}
+
 func fileExist(path string) bool {
 	_, err := os.Lstat(path)
 	return !os.IsNotExist(err)
-}
\ No newline at end of file
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"go-grpc/internal/pkg/envelope"
+	"go-grpc/internal/pkg/logging"
+	servicev2 "go-grpc/internal/service/server/v2"
+
+	"go.uber.org/zap"
+)
+
+// 按配置创建主密钥，没有开启加密时active为空，只用来解密已经加密的值
+func newKeyring() (*envelope.Keyring, error) {
+	keys := make(map[string][]byte, len(cfg.Encryption.MasterKeys))
+	for _, k := range cfg.Encryption.MasterKeys {
+		if _, ok := keys[k.ID]; ok {
+			return nil, fmt.Errorf("主密钥%s重复", k.ID)
+		}
+		key, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("主密钥%s不是有效的base64：%v", k.ID, err)
+		}
+		keys[k.ID] = key
+	}
+	var active string
+	if cfg.Encryption.Enabled {
+		if len(cfg.Encryption.ActiveKey) == 0 {
+			return nil, fmt.Errorf("开启加密需要配置activeKey")
+		}
+		active = cfg.Encryption.ActiveKey
+	}
+	return envelope.New(keys, active)
+}
+
+// RunReencrypt 用当前的主密钥重新加密所有的description，轮换主密钥之后执行；没有开启加密时把所有的值还原成明文
+// 和server读取同一份配置，服务运行时也可以执行
+func RunReencrypt() error {
+	var err error
+	cfg, err = newConfig()
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败：%v", err)
+	}
+	logger, err := logging.New(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+	keys, err := newKeyring()
+	if err != nil {
+		return fmt.Errorf("初始化加密失败：%v", err)
+	}
+	db, err := openDB(dataSourceName())
+	if err != nil {
+		return fmt.Errorf("连接数据库失败: %v", err)
+	}
+	defer db.Close()
+	// 只用到数据库和主密钥
+	v2API := servicev2.NewToDoServiceServer(db, nil, nil, keys)
+	scanned, rewritten, err := v2API.ReencryptDescriptions(context.Background())
+	if err != nil {
+		return fmt.Errorf("重新加密失败，已检查%d条，重新写入%d条：%v", scanned, rewritten, err)
+	}
+	zap.L().Info("重新加密完成", zap.String("activeKey", keys.Active()), zap.Int64("scanned", scanned), zap.Int64("rewritten", rewritten))
+	return nil
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	v1 "go-grpc/api/server/v1"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/errs"
+	"go-grpc/internal/pkg/exchange"
+	"go-grpc/internal/pkg/logging"
+	service "go-grpc/internal/service/server/v1"
+	servicev2 "go-grpc/internal/service/server/v2"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+)
+
+// 批量导入导出的HTTP接口，gateway不支持文件下载和multipart上传，所以直接注册在http的mux上
+// GET  /v1/todo:export?format={ndjson|csv|ics}                   下载导出文件
+// POST /v1/todo:import?format={ndjson|csv|ics}&dryRun={bool}    multipart上传，文件放在file字段
+// v2的路径是/v2/todos:export和/v2/todos:import，两个版本只有导入的响应格式不同
+func registerExchangeHandler(mux *http.ServeMux, svc *servicev2.ToDoServiceServer) {
+	registerExchangeVersion(mux, svc, "/v1/todo", v1.ToDoService_ServiceDesc.ServiceName, func(res *v2.ImportResponse) interface{} {
+		return service.ToV1ImportResponse(res)
+	})
+	registerExchangeVersion(mux, svc, "/v2/todos", v2.ToDoService_ServiceDesc.ServiceName, func(res *v2.ImportResponse) interface{} {
+		return res
+	})
+}
+
+func registerExchangeVersion(mux *http.ServeMux, svc *servicev2.ToDoServiceServer, collection, serviceName string, respond func(res *v2.ImportResponse) interface{}) {
+	mux.Handle(collection+":export", protectHTTP(map[string]string{http.MethodGet: fullMethod(serviceName, "Export")}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeStatusError(w, r, errs.New(codes.Unimplemented, errs.ReasonUnsupportedRequest, fmt.Sprintf("不支持的请求：%s %s", r.Method, r.URL.Path)))
+			return
+		}
+		format, err := exchange.ParseFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, err.Error(), "field", "format"))
+			return
+		}
+		w.Header().Set("Content-Type", exchange.ContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="todo%s"`, exchange.Extension(format)))
+		// 已经开始写body之后就没法再返回错误的状态码了，只能记录下来
+		if err := svc.ExportTo(r.Context(), format, w); err != nil {
+			logging.FromContext(r.Context()).Error("导出失败", zap.Error(err))
+		}
+	})))
+	mux.Handle(collection+":import", protectHTTP(map[string]string{http.MethodPost: fullMethod(serviceName, "Import")}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeStatusError(w, r, errs.New(codes.Unimplemented, errs.ReasonUnsupportedRequest, fmt.Sprintf("不支持的请求：%s %s", r.Method, r.URL.Path)))
+			return
+		}
+		query := r.URL.Query()
+		format, err := exchange.ParseFormat(query.Get("format"))
+		if err != nil {
+			writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, err.Error(), "field", "format"))
+			return
+		}
+		dryRun := false
+		if v := query.Get("dryRun"); len(v) > 0 {
+			if dryRun, err = strconv.ParseBool(v); err != nil {
+				writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, "dryRun参数无效", "field", "dryRun"))
+				return
+			}
+		}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, "请求不是multipart格式："+err.Error()))
+			return
+		}
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				writeStatusError(w, r, errs.InvalidArgument(errs.ReasonInvalidArgument, "找不到file字段", "field", "file"))
+				return
+			}
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+			res, err := svc.ImportFrom(r.Context(), format, dryRun, part)
+			part.Close()
+			if err != nil {
+				writeStatusError(w, r, err)
+				return
+			}
+			writeMessage(w, http.StatusOK, respond(res))
+			return
+		}
+	})))
+}
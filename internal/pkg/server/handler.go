@@ -1,44 +1,86 @@
 package server
 
 import (
-	"net/http"
-	"strings"
-	"log"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go-grpc/internal/pkg/i18n"
+	"go-grpc/internal/pkg/logging"
+	"go-grpc/internal/pkg/recovery"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"net/http"
 	"path"
+	"strings"
 )
 
+// 自定义的HTTP接口也用gateway的JSON格式输出，保证和gateway的接口风格一致
+var jsonMarshaler runtime.Marshaler = &runtime.JSONPb{}
+
 func GrpcHandlerFunc(grpcServer *grpc.Server, otherHandler http.Handler) http.Handler {
 	if otherHandler == nil {
-		return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			grpcServer.ServeHTTP(w, r)
 		})
 	}
 
 	// 这是一个兼容grpc请求和http请求的handler，可以根据请求类型判断，从而选择某个handler去完成这个请求
 	// 但是如果使用grpc Server的serveHTTP方法的话，要求必须要有TLS协议，也就是HTTPS，所以这里HTTP是不行的
-	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request) {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.ProtoMajor == 2 && (strings.Contains(r.Header.Get("Content-Type"), "application/grpc") || len(r.Header) == 0) {
-			log.Printf("RPC Request: %s %s from %s\n", r.Method, r.RequestURI, r.RemoteAddr)
 			grpcServer.ServeHTTP(w, r)
 		} else {
-			// 输出请求的详情，比如HTTP Request: Method URI from RemoteAddr
-			log.Printf("%s Request: %s %s from %s\n", r.Proto, r.Method, r.RequestURI, r.RemoteAddr)
 			otherHandler.ServeHTTP(w, r)
 		}
 	})
 }
 
+// RecoverHandler 把h中的panic转换成Internal错误返回，http.ErrAbortHandler是主动中断请求，继续交给net/http处理
+func RecoverHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				writeStatusError(w, r, recovery.Recovered(recovery.SourceHTTP, r.Method+" "+r.URL.Path, p))
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
 func SwaggerFileFunc(w http.ResponseWriter, r *http.Request) {
-	if ! strings.HasSuffix(r.URL.Path, "swagger.json") {
-        log.Printf("Not Found: %s", r.URL.Path)
-        http.NotFound(w, r)
-        return
-    }
+	if !strings.HasSuffix(r.URL.Path, "swagger.json") {
+		logging.FromContext(r.Context()).Debug("swagger文件不存在", zap.String("path", r.URL.Path))
+		http.NotFound(w, r)
+		return
+	}
 
 	// p是获取URL中的文件名
-    p := strings.TrimPrefix(r.URL.Path, "/swagger/")
-	// 
-    p = path.Join(BaseDir, "../../api/server/v1", p)
-    http.ServeFile(w, r, p)
-}
\ No newline at end of file
+	p := strings.TrimPrefix(r.URL.Path, "/swagger/")
+	// /swagger/v2/xxx.swagger.json对应v2的文件，不带版本号的保持原来的行为，对应v1
+	if !strings.HasPrefix(p, "v1/") && !strings.HasPrefix(p, "v2/") {
+		p = path.Join("v1", p)
+	}
+	p = path.Join(BaseDir, "../../api/server", path.Clean("/"+p))
+	http.ServeFile(w, r, p)
+}
+
+// 把proto消息按gateway的JSON格式写回去
+func writeMessage(w http.ResponseWriter, code int, msg interface{}) {
+	buf, err := jsonMarshaler.Marshal(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", jsonMarshaler.ContentType(msg))
+	w.WriteHeader(code)
+	w.Write(buf)
+}
+
+// 错误的格式和gateway保持一致，都是google.rpc.Status的JSON，HTTP状态码也按gateway的规则转换
+// 和gRPC接口一样按请求的Accept-Language带上本地化的提示
+func writeStatusError(w http.ResponseWriter, r *http.Request, err error) {
+	st := status.Convert(i18n.Localize(err, i18n.MatchLocale(r.Header.Get("Accept-Language"))))
+	writeMessage(w, runtime.HTTPStatusFromCode(st.Code()), st.Proto())
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+
+	"go-grpc/internal/pkg/i18n"
+	"go-grpc/internal/pkg/logging"
+	"go-grpc/internal/pkg/metrics"
+	"go-grpc/internal/pkg/middleware"
+	"go-grpc/internal/pkg/recovery"
+	"go-grpc/internal/pkg/tracing"
+	"go-grpc/internal/pkg/validate"
+	servicev2 "go-grpc/internal/service/server/v2"
+	"google.golang.org/grpc"
+)
+
+// 配置中没有middleware.chain时使用的顺序
+// i18n要在recovery和validate外面，才能本地化它们返回的错误；tracing在logging里面，才能把trace ID加到日志中
+// auth在validate前面，没有认证的请求不会拿到校验的详情；ratelimit和rbac要在auth里面，才能拿到调用方的身份
+// concurrency在auth外面，API key的认证也要查数据库；audit紧跟在auth后面，被限流和RBAC拒绝的修改也会记录
+var defaultMiddlewareChain = []string{"logging", "tracing", "metrics", "i18n", "recovery", "concurrency", "auth", "audit", "ratelimit", "rbac", "validate"}
+
+func init() {
+	middleware.Register("logging", func() (middleware.Middleware, error) {
+		opts := logging.Options{Payload: cfg.Log.Payload, Redact: cfg.Log.Redact}
+		return middleware.Middleware{Unary: logging.UnaryServerInterceptor(opts), Stream: logging.StreamServerInterceptor(opts)}, nil
+	})
+	middleware.Register("tracing", func() (middleware.Middleware, error) {
+		if !cfg.Tracing.Enabled {
+			return middleware.Middleware{}, nil
+		}
+		return middleware.Middleware{Unary: tracing.UnaryServerInterceptor(), Stream: tracing.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("metrics", func() (middleware.Middleware, error) {
+		return middleware.Middleware{Unary: metrics.UnaryServerInterceptor(), Stream: metrics.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("i18n", func() (middleware.Middleware, error) {
+		return middleware.Middleware{Unary: i18n.UnaryServerInterceptor(), Stream: i18n.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("recovery", func() (middleware.Middleware, error) {
+		return middleware.Middleware{Unary: recovery.UnaryServerInterceptor(), Stream: recovery.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("concurrency", func() (middleware.Middleware, error) {
+		if shedder == nil {
+			return middleware.Middleware{}, nil
+		}
+		return middleware.Middleware{Unary: shedder.UnaryServerInterceptor(), Stream: shedder.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("auth", func() (middleware.Middleware, error) {
+		if guard == nil {
+			return middleware.Middleware{}, nil
+		}
+		return middleware.Middleware{Unary: guard.UnaryServerInterceptor(), Stream: guard.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("audit", func() (middleware.Middleware, error) {
+		if auditLog == nil {
+			return middleware.Middleware{}, nil
+		}
+		return middleware.Middleware{Unary: auditLog.UnaryServerInterceptor(), Stream: auditLog.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("ratelimit", func() (middleware.Middleware, error) {
+		if limiter == nil {
+			return middleware.Middleware{}, nil
+		}
+		return middleware.Middleware{Unary: limiter.UnaryServerInterceptor(), Stream: limiter.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("rbac", func() (middleware.Middleware, error) {
+		if policy == nil {
+			return middleware.Middleware{}, nil
+		}
+		return middleware.Middleware{Unary: policy.UnaryServerInterceptor(), Stream: policy.StreamServerInterceptor()}, nil
+	})
+	middleware.Register("validate", func() (middleware.Middleware, error) {
+		return middleware.Middleware{Unary: validate.UnaryServerInterceptor(), Stream: validate.StreamServerInterceptor()}, nil
+	})
+}
+
+// 数据库连接池和ToDo业务的指标
+func registerMetrics(db *sql.DB, v2API *servicev2.ToDoServiceServer) error {
+	if err := metrics.RegisterDBStats(cfg.Mysql.DBSchema, db); err != nil {
+		return err
+	}
+	return metrics.RegisterToDoStats(func(ctx context.Context) (metrics.ToDoStats, error) {
+		total, pending, err := v2API.Stats(ctx)
+		return metrics.ToDoStats{Total: total, Pending: pending}, err
+	})
+}
+
+// 按配置组装拦截器链
+func middlewareOptions() ([]grpc.ServerOption, error) {
+	names := cfg.Middleware.Chain
+	if len(names) == 0 {
+		names = defaultMiddlewareChain
+	}
+	return middleware.Chain(names)
+}
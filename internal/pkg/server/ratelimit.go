@@ -0,0 +1,19 @@
+package server
+
+import (
+	"go-grpc/internal/pkg/ratelimit"
+)
+
+// 开启限流时在启动时创建，gRPC的拦截器和直接注册在mux上的HTTP接口共用同一批令牌桶
+var limiter *ratelimit.Limiter
+
+func newLimiter() (*ratelimit.Limiter, error) {
+	if !cfg.RateLimit.Enabled {
+		return nil, nil
+	}
+	rules := make([]ratelimit.Rule, 0, len(cfg.RateLimit.Rules))
+	for _, r := range cfg.RateLimit.Rules {
+		rules = append(rules, ratelimit.Rule{Methods: r.Methods, Rate: r.Rate, Burst: r.Burst})
+	}
+	return ratelimit.New(ratelimit.Rule{Rate: cfg.RateLimit.Rate, Burst: cfg.RateLimit.Burst}, rules)
+}
@@ -1,27 +1,44 @@
 package server
 
 import (
-	"database/sql"
 	"context"
+	"database/sql"
 	v1 "go-grpc/api/server/v1"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/auth"
+	"go-grpc/internal/pkg/blob"
+	"go-grpc/internal/pkg/certs"
+	"go-grpc/internal/pkg/health"
+	"go-grpc/internal/pkg/logging"
+	"go-grpc/internal/pkg/metrics"
+	"go-grpc/internal/pkg/ratelimit"
+	"go-grpc/internal/pkg/search"
+	"go-grpc/internal/pkg/tracing"
 	service "go-grpc/internal/service/server/v1"
+	servicev2 "go-grpc/internal/service/server/v2"
 	// swagger "go-grpc/internal/pkg/swagger"
 	// "github.com/elazarl/go-bindata-assetfs"
-	"go-grpc/internal/pkg/util"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
-	"log"
+	"github.com/go-sql-driver/mysql"
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	
-	"golang.org/x/sync/errgroup"
-	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+
 	"crypto/tls"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var cfg *Config
@@ -34,6 +51,34 @@ func RunServer() error {
 	if err != nil {
 		return fmt.Errorf("读取配置文件失败：%v", err)
 	}
+	// 按配置创建结构化日志，标准库log的输出也转到这里
+	logger, err := logging.New(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+	zap.RedirectStdLog(logger)
+	// 链路追踪，退出前把还没导出的span发送出去
+	if cfg.Tracing.Enabled {
+		shutdown, err := tracing.Init(context.Background(), tracing.Options{
+			ServiceName: cfg.Tracing.ServiceName,
+			Exporter:    cfg.Tracing.Exporter,
+			Endpoint:    cfg.Tracing.Endpoint,
+			Insecure:    cfg.Tracing.Insecure,
+			SampleRatio: cfg.Tracing.SampleRatio,
+		})
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				zap.L().Warn("导出剩余的span失败", zap.Error(err))
+			}
+		}()
+	}
 
 	// 创建http server的listen
 	gListen, err := net.Listen("tcp", cfg.Server.Proxy)
@@ -43,21 +88,119 @@ func RunServer() error {
 
 	// 连接数据库，数据库实例是用于创建server stub的，实际上这种设计明显不好，直接将DAO放在service
 	// 实际上service应该依赖于DAO的抽象接口，而DAO下面可以实现依赖倒置
-	param := "parseTime=true"
-	dsn := fmt.Sprintf("%s:%s@tcp(%s)/%s?%s", 
-								cfg.Mysql.User, cfg.Mysql.Password, cfg.Mysql.Host, cfg.Mysql.DBSchema, param)
-	db, err := sql.Open("mysql", dsn)
+	db, err := openDB(dataSourceName())
 	if err != nil {
 		return fmt.Errorf("连接数据库失败: %v", err)
 	}
+	// 没有配置时保持database/sql的默认值
+	if cfg.Mysql.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.Mysql.MaxOpenConns)
+	}
+	if cfg.Mysql.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.Mysql.MaxIdleConns)
+	}
+	// 附件内容保存在本地文件系统
+	blobs, err := blob.NewLocalStore(cfg.Attachment.Dir)
+	if err != nil {
+		return fmt.Errorf("创建附件目录失败: %v", err)
+	}
+	// 全文搜索，MySQL直接用FULLTEXT索引，否则使用进程内的倒排索引
+	var engine search.Engine
+	switch cfg.Search.Backend {
+	case "", "mysql":
+		engine = search.NewMySQLEngine(db)
+	case "memory":
+		engine = search.NewMemoryIndex()
+	default:
+		return fmt.Errorf("不支持的搜索后端：%s", cfg.Search.Backend)
+	}
+	// description加密用的主密钥
+	keys, err := newKeyring()
+	if err != nil {
+		return fmt.Errorf("初始化加密失败：%v", err)
+	}
+	if keys.Active() != "" && cfg.Search.Backend != "memory" {
+		zap.L().Warn("开启了description加密，MySQL的全文搜索只能匹配title")
+	}
 	// 创建一个server stub，等下注册到grpc server中，因为强依赖了一个DB，所以要在这一层cancel的时候把它close掉
-	v1API := service.NewToDoServiceServer(db)
-	
+	// 真正的实现在v2，v1只是一个适配层，两个版本同时注册到同一个grpc server和gateway中
+	v2API := servicev2.NewToDoServiceServer(db, blobs, engine, keys)
+	v1API := service.NewToDoServiceServer(v2API)
+	keyAPI := servicev2.NewAPIKeyServiceServer(db)
+	// 证书在认证和TLS的配置中都会用到
+	if cfg.Server.TLS.Enabled {
+		if cfg.Server.TLS.Dev.Enabled {
+			if err := ensureDevCerts(); err != nil {
+				return fmt.Errorf("生成开发用的证书失败：%v", err)
+			}
+		}
+		certManager, err = certs.NewManager(cfg.Server.TLS.CertPemPath, cfg.Server.TLS.CertKeyPath)
+		if err != nil {
+			return fmt.Errorf("读取TLS文件失败：%v", err)
+		}
+	}
+	// 认证要在创建grpc server和HTTP接口之前准备好，API key保存在数据库中
+	guard, err = newAuthGuard(keyAPI)
+	if err != nil {
+		return fmt.Errorf("初始化认证失败：%v", err)
+	}
+	policy, err = newPolicy()
+	if err != nil {
+		return fmt.Errorf("初始化RBAC失败：%v", err)
+	}
+	limiter, err = newLimiter()
+	if err != nil {
+		return fmt.Errorf("初始化限流失败：%v", err)
+	}
+	shedder, err = newShedder()
+	if err != nil {
+		return fmt.Errorf("初始化并发限制失败：%v", err)
+	}
+	auditLog, err = newAuditLog()
+	if err != nil {
+		return fmt.Errorf("打开审计日志失败：%v", err)
+	}
+	if auditLog != nil {
+		defer auditLog.Close()
+	}
+	if cfg.Search.Backend == "memory" {
+		if err := v2API.RebuildSearchIndex(context.Background()); err != nil {
+			return fmt.Errorf("创建搜索索引失败: %v", err)
+		}
+	}
+
+	// 数据库连接池和ToDo的业务指标，在抓取/metrics时读取
+	if cfg.Metrics.Enabled {
+		if err := registerMetrics(db, v2API); err != nil {
+			return fmt.Errorf("注册监控指标失败: %v", err)
+		}
+	}
+
+	// 就绪状态取决于数据库，整个服务器和两个版本的服务都报告同一个状态
+	checker := health.NewChecker(db, v1.ToDoService_ServiceDesc.ServiceName, v2.ToDoService_ServiceDesc.ServiceName)
+
 	// 创建context
 	ctx, cancel := context.WithCancel(context.Background())
 	// 这里尝试使用errgroup，对os.signal和ctx的管道进行监听，并且开启服务
 	g, ctx := errgroup.WithContext(ctx)
-	
+	g.Go(func() error {
+		checker.Run(ctx)
+		return nil
+	})
+	// 证书文件更新之后自动重新加载，不需要重启
+	if certManager != nil {
+		g.Go(func() error {
+			if err := certManager.Watch(ctx); err != nil {
+				zap.L().Error("监听证书文件失败，证书更新之后需要重启", zap.Error(err))
+			}
+			return nil
+		})
+	}
+
+	// gateway到grpc的连接在这个context取消时关闭，不能用上面的ctx，否则一开始关闭REST请求就全部失败了
+	serveCtx, stopServe := context.WithCancel(context.Background())
+	defer stopServe()
+
 	// 创建通用型server，如果开启了TLS，那么grpc+gateway都会在这个server
 	var server *http.Server
 
@@ -67,75 +210,142 @@ func RunServer() error {
 	// tls的config，开启TLS的话，这个指针就会被初始化
 	var tlsConfig *tls.Config
 
-	if !cfg.Server.TLS.Enabled {  // 如果没有开启TLS，则直接用grpc和gateway分离的方式
+	if !cfg.Server.TLS.Enabled { // 如果没有开启TLS，则直接用grpc和gateway分离的方式
+		if clientAuthEnabled() {
+			cancel()
+			return fmt.Errorf("验证客户端证书需要开启TLS")
+		}
 		listen, err := net.Listen("tcp", cfg.Server.Host)
 		if err != nil {
 			cancel()
 			return fmt.Errorf("错误的server端口配置：%v", err)
 		}
-		// 如果没有开启TLS，grpcServer一般不会报错
-		grpcServer, _ = newGrpcServer(v1API, false)
+		// 如果没有开启TLS，只有middleware配置有问题时才会报错
+		grpcServer, err = newGrpcServer(v1API, v2API, keyAPI, checker.Server(), nil)
+		if err != nil {
+			cancel()
+			return fmt.Errorf("创建GRPC服务失败：%v", err)
+		}
 		g.Go(func() error {
 			return grpcServer.Serve(listen)
 		})
-		log.Printf("GRPC服务开启监听，服务Host：%s\n", cfg.Server.Host)
+		zap.L().Info("GRPC服务开启监听", zap.String("host", cfg.Server.Host))
 		// 创建gateway的server，没有grpc
-		server = newServer(ctx, nil, nil)
+		server = newServer(serveCtx, nil, v1API, v2API, keyAPI, checker)
 	} else {
 		// 开启了TLS，则首先初始化tls的config
-		tlsConfig, err = util.GetTLSConfig(cfg.Server.TLS.CertPemPath, cfg.Server.TLS.CertKeyPath)
+		tlsConfig, err = newTLSConfig()
 		if err != nil {
 			cancel()
-			return fmt.Errorf("读取TLS文件失败：%v", err)
+			return err
 		}
 		// 然后创建一个通用的server，包含grpc和gateway
-		server = newServer(ctx, tlsConfig, v1API)
+		server = newServer(serveCtx, tlsConfig, v1API, v2API, keyAPI, checker)
 	}
 
 	// 开启server服务监听，这是一个HTTP的server，如果开启了TLS，它可以整合grpc和HTTP的监听，否则只能作为grpc的gateway
-	g.Go(func () error {
+	g.Go(func() error {
 		if cfg.Server.TLS.Enabled {
 			return server.Serve(tls.NewListener(gListen, tlsConfig))
 		} else {
 			return server.Serve(gListen)
 		}
-		
+
 	})
-	log.Printf("服务开启监听，服务Host：%s\n", cfg.Server.Proxy)
-	
+	zap.L().Info("服务开启监听", zap.String("host", cfg.Server.Proxy))
+
+	// 配置了单独的地址时，/metrics不挂在对外的服务上，而是单独监听
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled && len(cfg.Metrics.Addr) > 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle(cfg.Metrics.Path, promhttp.Handler())
+		metricsServer = &http.Server{Addr: cfg.Metrics.Addr, Handler: metricsMux}
+		g.Go(func() error {
+			return metricsServer.ListenAndServe()
+		})
+		zap.L().Info("监控指标开启监听", zap.String("host", cfg.Metrics.Addr))
+	}
 
-	// 创建信号监听
+	// 创建信号监听，只关心退出的信号，Go运行时自己也会收到SIGURG之类的信号
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 	// 监听ctx.Done和signal
 	g.Go(func() error {
-		for {
+		select {
+		case s := <-signalChan:
+			zap.L().Info("接收到信号，准备关闭服务", zap.Stringer("signal", s), zap.Duration("drain", cfg.Server.Shutdown.Drain))
+			// 先把就绪状态改为NOT_SERVING，探针不再把流量转发过来，这段时间内照常处理请求
+			checker.Shutdown()
 			select {
-			case s := <-signalChan:
-				// 调用cancel，关闭ctx.Done管道，让所有goroutine都关闭
-				cancel()
-				log.Printf("接收到 \"%v\" 信号!准备关闭服务\n", s)
-			case <-ctx.Done(): 
-				// Done在调用cancel、timeout、deadlien后都会被close，所以把自己关掉
-				server.Shutdown(ctx)
-				if !cfg.Server.TLS.Enabled {
-					grpcServer.GracefulStop()
-				}
-				return ctx.Err()
+			case <-time.After(cfg.Server.Shutdown.Drain):
+			case <-ctx.Done():
 			}
+			// 调用cancel，关闭ctx.Done管道，让所有goroutine都关闭
+			cancel()
+		case <-ctx.Done():
+			// 其他goroutine出错时不用等待，直接关闭
+			checker.Shutdown()
+		}
+		// ctx已经取消了，用新的context限制等待处理中的请求的时间
+		shutdownCtx, done := context.WithTimeout(context.Background(), cfg.Server.Shutdown.Timeout)
+		defer done()
+		server.Shutdown(shutdownCtx)
+		if metricsServer != nil {
+			metricsServer.Shutdown(shutdownCtx)
+		}
+		if grpcServer != nil {
+			stopGrpcServer(shutdownCtx, grpcServer)
 		}
+		return ctx.Err()
 	})
 
 	if err := g.Wait(); err != nil {
 		db.Close()
-		log.Printf("服务退出，原因：%v\n", err)
+		zap.L().Info("服务退出", zap.Error(err))
 	}
 	return err
 }
 
+// 等处理中的调用结束，超时之后直接断开，GracefulStop会一直等到流结束
+func stopGrpcServer(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+// 按配置拼接MySQL的DSN
+func dataSourceName() string {
+	param := "parseTime=true"
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?%s",
+		cfg.Mysql.User, cfg.Mysql.Password, cfg.Mysql.Host, cfg.Mysql.DBSchema, param)
+}
+
+// 打开数据库，开启链路追踪时每一条SQL都会记录一个span
+func openDB(dsn string) (*sql.DB, error) {
+	if !cfg.Tracing.Enabled {
+		return sql.Open("mysql", dsn)
+	}
+	mcfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := mysql.NewConnector(mcfg)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(tracing.WrapConnector(connector, "mysql", cfg.Mysql.DBSchema)), nil
+}
+
 // 创建http服务的server，如果有tls.Config，则连同grpc一起创建
-func newServer(ctx context.Context, tlsConfig *tls.Config, v1API v1.ToDoServiceServer) *http.Server {
-		// 创建gateway的mux
+func newServer(ctx context.Context, tlsConfig *tls.Config, v1API v1.ToDoServiceServer, v2API *servicev2.ToDoServiceServer, keyAPI v2.APIKeyServiceServer, checker *health.Checker) *http.Server {
+	// 创建gateway的mux
 	gmux, err := newGateway(ctx)
 	if err != nil {
 		panic(err)
@@ -146,63 +356,120 @@ func newServer(ctx context.Context, tlsConfig *tls.Config, v1API v1.ToDoServiceS
 	mux.Handle("/", gmux)
 	mux.HandleFunc("/swagger/", SwaggerFileFunc)
 	registerSwaggerUI(mux)
+	registerAttachmentHandler(mux, v2API)
+	registerExchangeHandler(mux, v2API)
+	registerCalendarHandler(mux, v2API)
+	checker.RegisterHandlers(mux)
+	// 指标中有接口、调用方和数据量的信息，挂在对外的服务上时不能匿名访问
+	if cfg.Metrics.Enabled && len(cfg.Metrics.Addr) == 0 {
+		mux.Handle(cfg.Metrics.Path, protectHTTP(nil, promhttp.Handler()))
+	}
 
-	// 先初始化一个handler
+	// 先初始化一个handler，grpc的请求由拦截器记录日志，其他的请求由HTTP中间件记录
 	var handler http.Handler
+	httpHandler := http.Handler(mux)
+	if cfg.Tracing.Enabled {
+		httpHandler = tracing.HTTPMiddleware(httpHandler)
+	}
+	httpHandler = logging.HTTPMiddleware(metrics.HTTPMiddleware(httpHandler))
 	// 创建grpc server
 	var grpcServer *grpc.Server
 	if tlsConfig != nil {
-		grpcServer, err = newGrpcServer(v1API, true)
-		handler = GrpcHandlerFunc(grpcServer, mux)
+		grpcServer, err = newGrpcServer(v1API, v2API, keyAPI, checker.Server(), tlsConfig)
+		if err != nil {
+			panic(err)
+		}
+		handler = GrpcHandlerFunc(grpcServer, httpHandler)
 	} else {
-		handler = mux
+		handler = httpHandler
 	}
+	// gateway和自定义HTTP接口中的panic不能让进程退出
+	handler = RecoverHandler(handler)
 
 	// 创建一个http.Server，并返回
-	return &http.Server {
-		Addr: cfg.Server.Host,
+	return &http.Server{
+		Addr:    cfg.Server.Host,
 		Handler: handler,
 	}
 }
 
 // 创建一个GRPC的server
-func newGrpcServer(v1API v1.ToDoServiceServer, tls bool) (*grpc.Server, error) {
-	// grpc的选项，根据有没有开启TLS来创建
+func newGrpcServer(v1API v1.ToDoServiceServer, v2API v2.ToDoServiceServer, keyAPI v2.APIKeyServiceServer, healthAPI healthpb.HealthServer, tlsConfig *tls.Config) (*grpc.Server, error) {
+	// grpc的选项，根据有没有开启TLS来创建，和HTTP共用一个tls.Config，客户端证书的验证也一样
 	var opts []grpc.ServerOption
-	if tls {
-		creds, err := credentials.NewServerTLSFromFile(cfg.Server.TLS.CertPemPath, cfg.Server.TLS.CertKeyPath)
-		if err != nil {
-			return nil, err
-		}
-		opts = append(opts, grpc.Creds(creds))
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	// 日志、恢复panic、校验等横切功能按配置的顺序组装成拦截器链
+	chain, err := middlewareOptions()
+	if err != nil {
+		return nil, err
 	}
+	opts = append(opts, chain...)
 	// 向grpc注册server stub
 	grpcServer := grpc.NewServer(opts...)
 	v1.RegisterToDoServiceServer(grpcServer, v1API)
+	v2.RegisterToDoServiceServer(grpcServer, v2API)
+	v2.RegisterAPIKeyServiceServer(grpcServer, keyAPI)
+	healthpb.RegisterHealthServer(grpcServer, healthAPI)
+	// 开发调试用：反射让grpcurl、grpcui不需要.proto就能调用接口，channelz可以查看连接和调用的统计
+	if cfg.Debug.Reflection {
+		reflection.Register(grpcServer)
+	}
+	if cfg.Debug.Channelz {
+		channelz.RegisterChannelzServiceToServer(grpcServer)
+	}
 	return grpcServer, nil
 }
 
-//  根据官方的提示，创建一个gateway的mux
+// 根据官方的提示，创建一个gateway的mux
 func newGateway(ctx context.Context) (http.Handler, error) {
 	var endpoint string
 	var opts []grpc.DialOption
 	// 如果有开启TLS，则gateway和grpc是处于同一个proxy端口的
 	if cfg.Server.TLS.Enabled {
 		endpoint = cfg.Server.Proxy
-		dcreds, err := credentials.NewClientTLSFromFile(cfg.Server.TLS.CertPemPath, cfg.Server.TLS.CommonName)
-		if err != nil {
-			return nil, err
-		}
-		opts = append(opts, grpc.WithTransportCredentials(dcreds))
+		opts = append(opts, grpc.WithTransportCredentials(gatewayCredentials()))
 	} else {
 		endpoint = cfg.Server.Host
 		opts = append(opts, grpc.WithInsecure())
 	}
-	mux := runtime.NewServeMux()
+	// HTTP请求上的trace通过客户端拦截器传递给grpc
+	if cfg.Tracing.Enabled {
+		opts = append(opts, tracing.DialOptions()...)
+	}
+	// 请求ID和API key不是IANA的标准头，默认不会转发，这里单独转发，让gateway和grpc的日志能对应起来，API key也能在gRPC中认证
+	// Authorization头gateway默认就会以authorization转发，gRPC的认证拦截器对REST请求同样生效
+	mux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+		switch {
+		case strings.EqualFold(key, logging.RequestIDHeader):
+			return logging.RequestIDHeader, true
+		case strings.EqualFold(key, auth.APIKeyHeader):
+			return auth.APIKeyHeader, true
+		case strings.EqualFold(key, runtime.MetadataHeaderPrefix+auth.ForwardedCertHeader):
+			// 客户端证书只能由gateway转发，不能由调用方自己伪造
+			return "", false
+		}
+		return runtime.DefaultHeaderMatcher(key)
+	}), runtime.WithOutgoingHeaderMatcher(func(key string) (string, bool) {
+		// 限流时的等待时间按HTTP的标准头返回，其他的保持gateway默认的Grpc-Metadata-前缀
+		if key == ratelimit.RetryAfterHeader {
+			return "Retry-After", true
+		}
+		return runtime.MetadataHeaderPrefix + key, true
+	}), runtime.WithMetadata(forwardClientCert))
 	err := v1.RegisterToDoServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
 	if err != nil {
 		return nil, err
 	}
+	err = v2.RegisterToDoServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = v2.RegisterAPIKeyServiceHandlerFromEndpoint(ctx, mux, endpoint, opts)
+	if err != nil {
+		return nil, err
+	}
 	return mux, nil
 }
 
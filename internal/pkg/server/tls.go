@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go-grpc/internal/pkg/auth"
+	"go-grpc/internal/pkg/certs"
+	"go-grpc/internal/pkg/util"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+// 开启TLS时在启动时创建，HTTP的监听、gRPC的credentials和gateway的连接共用，证书文件变化时自动重新加载
+var certManager *certs.Manager
+
+// 开发模式下在证书所在的目录生成CA、服务端和客户端证书，没有配置客户端CA时使用生成的CA
+func ensureDevCerts() error {
+	dir := filepath.Dir(cfg.Server.TLS.CertPemPath)
+	hosts := append([]string{cfg.Server.TLS.CommonName}, cfg.Server.TLS.Dev.Hosts...)
+	for _, addr := range []string{cfg.Server.Host, cfg.Server.Proxy} {
+		// 监听所有地址（0.0.0.0）时不是客户端能连接的名字
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil || len(host) == 0 {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	generated, err := certs.EnsureDev(certs.DevOptions{
+		Dir:         dir,
+		CommonName:  cfg.Server.TLS.CommonName,
+		Hosts:       hosts,
+		ClientName:  "go-grpc-client",
+		ClientUnits: []string{"dev"},
+		ValidFor:    365 * 24 * time.Hour,
+	})
+	if err != nil {
+		return err
+	}
+	cfg.Server.TLS.CertPemPath = filepath.Join(dir, certs.ServerFile)
+	cfg.Server.TLS.CertKeyPath = filepath.Join(dir, certs.ServerKeyFile)
+	if len(cfg.Server.TLS.ClientCAPath) == 0 {
+		cfg.Server.TLS.ClientCAPath = filepath.Join(dir, certs.CAFile)
+	}
+	if generated {
+		zap.L().Warn("已生成开发用的自签名证书，不要在生产环境使用", zap.String("dir", dir), zap.Strings("hosts", hosts))
+	}
+	return nil
+}
+
+// 是否开启了客户端证书的验证
+func clientAuthEnabled() bool {
+	mode, err := util.ParseClientAuth(cfg.Server.TLS.ClientAuth)
+	return err == nil && mode != tls.NoClientCert
+}
+
+// 创建服务端的tls.Config，HTTP的监听和gRPC的credentials用的是同一个
+func newTLSConfig() (*tls.Config, error) {
+	tlsConfig := util.GetTLSConfig(certManager.GetCertificate)
+	if err := configureClientAuth(tlsConfig); err != nil {
+		return nil, fmt.Errorf("配置客户端证书验证失败：%v", err)
+	}
+	return tlsConfig, nil
+}
+
+// 按配置验证客户端证书，gateway用服务端证书连接gRPC，所以服务端证书本身也要被信任
+func configureClientAuth(tlsConfig *tls.Config) error {
+	mode, err := util.ParseClientAuth(cfg.Server.TLS.ClientAuth)
+	if err != nil || mode == tls.NoClientCert {
+		return err
+	}
+	if len(cfg.Server.TLS.ClientCAPath) == 0 {
+		return fmt.Errorf("验证客户端证书需要配置clientCAPath")
+	}
+	pem, err := ioutil.ReadFile(cfg.Server.TLS.ClientCAPath)
+	if err != nil {
+		return err
+	}
+	cas := &clientCAs{pem: pem}
+	if _, err := cas.pool(certManager.Leaf()); err != nil {
+		return err
+	}
+	tlsConfig.ClientAuth = mode
+	// 服务端证书轮换之后，信任列表跟着换成新的证书
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := cas.pool(certManager.Leaf())
+		if err != nil {
+			return nil, err
+		}
+		c := tlsConfig.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = pool
+		return c, nil
+	}
+	return nil
+}
+
+// 验证客户端证书的CA加上当前的服务端证书，服务端证书没有变化时复用
+type clientCAs struct {
+	pem []byte
+
+	mu       sync.Mutex
+	leaf     *x509.Certificate
+	certPool *x509.CertPool
+}
+
+func (c *clientCAs) pool(leaf *x509.Certificate) (*x509.CertPool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leaf == leaf {
+		return c.certPool, nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(c.pem) {
+		return nil, fmt.Errorf("%s中没有有效的证书", cfg.Server.TLS.ClientCAPath)
+	}
+	pool.AddCert(leaf)
+	c.leaf, c.certPool = leaf, pool
+	return pool, nil
+}
+
+// gateway连接gRPC的证书，连接的就是自己，所以直接比较对方出示的是不是当前加载的证书，证书轮换之后也不需要更新信任列表
+// 开启了mTLS时出示服务端证书
+func gatewayCredentials() credentials.TransportCredentials {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.Server.TLS.CommonName,
+		// 不按CA验证，由下面的VerifyConnection验证
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 || !bytes.Equal(cs.PeerCertificates[0].Raw, certManager.Leaf().Raw) {
+				return fmt.Errorf("gRPC服务出示的不是当前加载的证书")
+			}
+			return nil
+		},
+	}
+	if clientAuthEnabled() {
+		tlsConfig.GetClientCertificate = certManager.GetClientCertificate
+	}
+	return credentials.NewTLS(tlsConfig)
+}
+
+// 把REST调用方验证过的证书转发给gRPC，在gRPC中由CertAuthenticator识别
+func forwardClientCert(ctx context.Context, r *http.Request) metadata.MD {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return metadata.Pairs(auth.ForwardedCertHeader, base64.StdEncoding.EncodeToString(r.TLS.VerifiedChains[0][0].Raw))
+}
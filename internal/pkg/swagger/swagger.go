@@ -1,17 +1,17 @@
 package swagger
 
 import (
-	"strings"
-	"path"
+	"go.uber.org/zap"
 	"net/http"
-	"log"
+	"path"
+	"strings"
 	// "github.com/elazarl/go-bindata-assetfs"
 )
 
 func ServeSwaggerFile(w http.ResponseWriter, r *http.Request) {
 	// 判断有无指定对应的后缀
-	if ! strings.HasSuffix(r.URL.Path, "swagger.json") {
-		log.Printf("Not Found: %s", r.URL.Path)
+	if !strings.HasSuffix(r.URL.Path, "swagger.json") {
+		zap.L().Debug("swagger文件不存在", zap.String("path", r.URL.Path))
 		http.NotFound(w, r)
 		return
 	}
@@ -19,14 +19,14 @@ func ServeSwaggerFile(w http.ResponseWriter, r *http.Request) {
 	p := strings.TrimPrefix(r.URL.Path, "/swagger/")
 	p = path.Join("../../api/server/v1/", p)
 
-	log.Printf("Serving swagger-file: %s", p)
+	zap.L().Debug("返回swagger文件", zap.String("path", p))
 
 	http.ServeFile(w, r, p)
 }
 
 // 对xxx.swagger.json提供文件访问支持，利用的是third_party里面的dist文件
 func ServeSwaggerUI(mux *http.ServeMux) {
-	
+
 	// fileServer := http.FileServer(&assetfs.AssetFS{
 	// 	// 将datafile.go中的函数作为这个AssetFS结构体的参数，虽然暂时还不知道有什么用
 	// 	Asset: Asset,
@@ -36,4 +36,4 @@ func ServeSwaggerUI(mux *http.ServeMux) {
 	prefix := "/swagger-ui/"
 	// mux.Handle(prefix, http.StripPrefix(prefix, fileServer))
 	mux.Handle(prefix, http.StripPrefix(prefix, http.FileServer(http.Dir("../../internal/pkg/third_party/swagger-ui"))))
-}
\ No newline at end of file
+}
@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+
+	"go-grpc/internal/pkg/logging"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// 把trace ID加到请求日志中，日志和trace可以互相查找
+func logTraceID(ctx context.Context) {
+	if id := TraceID(ctx); len(id) > 0 {
+		logging.AddFields(ctx, zap.String("trace_id", id))
+	}
+}
+
+// UnaryServerInterceptor 从metadata中取出上游的trace，为每个unary调用创建一个server span
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	inner := otelgrpc.UnaryServerInterceptor()
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return inner(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			logTraceID(ctx)
+			return handler(ctx, req)
+		})
+	}
+}
+
+// StreamServerInterceptor 为每个流式调用创建一个server span，收发的每条消息记为span中的事件
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	inner := otelgrpc.StreamServerInterceptor()
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return inner(srv, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+			logTraceID(ss.Context())
+			return handler(srv, ss)
+		})
+	}
+}
+
+// DialOptions 是gateway连接gRPC时使用的客户端拦截器，把HTTP请求上的trace传递给gRPC
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	}
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// 每一批span的上报超时，collector不可用时不能一直占着导出的goroutine
+const exportTimeout = 10 * time.Second
+
+// grpcClient 通过gRPC把span上报给OTLP collector，实现otlptrace.Client
+// 这个连接本身不加追踪的拦截器，否则上报span又会产生新的span
+type grpcClient struct {
+	endpoint string
+	insecure bool
+
+	mu     sync.RWMutex
+	conn   *grpc.ClientConn
+	client coltracepb.TraceServiceClient
+}
+
+func newGRPCClient(endpoint string, insecure bool) *grpcClient {
+	if len(endpoint) == 0 {
+		endpoint = "localhost:4317"
+	}
+	return &grpcClient{endpoint: endpoint, insecure: insecure}
+}
+
+// Start 不等待连接建立，collector晚于服务启动也没关系
+func (c *grpcClient) Start(ctx context.Context) error {
+	var opts []grpc.DialOption
+	if c.insecure {
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, "")))
+	}
+	conn, err := grpc.DialContext(ctx, c.endpoint, opts...)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.client = coltracepb.NewTraceServiceClient(conn)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *grpcClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	c.client = nil
+	return err
+}
+
+func (c *grpcClient) UploadTraces(ctx context.Context, spans []*tracepb.ResourceSpans) error {
+	c.mu.RLock()
+	client := c.client
+	c.mu.RUnlock()
+	if client == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+	_, err := client.Export(ctx, &coltracepb.ExportTraceServiceRequest{ResourceSpans: spans})
+	return err
+}
@@ -0,0 +1,209 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapConnector 给数据库连接加上追踪，每一条SQL都是当前请求span下的一个client span
+// 只在context中已经有span时才记录，后台任务（比如抓取指标时的统计查询）不会产生孤立的trace
+func WrapConnector(c driver.Connector, system, dbName string) driver.Connector {
+	return &connector{Connector: c, attrs: []attribute.KeyValue{
+		semconv.DBSystemKey.String(system),
+		semconv.DBNameKey.String(dbName),
+	}}
+}
+
+type connector struct {
+	driver.Connector
+	attrs []attribute.KeyValue
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: cn, attrs: c.attrs}, nil
+}
+
+type conn struct {
+	driver.Conn
+	attrs []attribute.KeyValue
+}
+
+// 语句执行完才知道要不要记录（driver.ErrSkip表示驱动不支持，database/sql会换一种方式重新执行），所以先记下开始时间
+func (c *conn) record(ctx context.Context, op, query string, start time.Time, err error) {
+	if err == driver.ErrSkip || !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+	attrs := c.attrs
+	if len(query) > 0 {
+		attrs = append(attrs[:len(attrs):len(attrs)], semconv.DBStatementKey.String(query))
+	}
+	_, span := tracer().Start(ctx, "sql."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrs...),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	start := time.Now()
+	var st driver.Stmt
+	var err error
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		st, err = p.PrepareContext(ctx, query)
+	} else {
+		st, err = c.Conn.Prepare(query)
+	}
+	c.record(ctx, "prepare", query, start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: st, conn: c, query: query}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var tx driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	c.record(ctx, "begin", "", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, conn: c, ctx: ctx}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err := e.ExecContext(ctx, query, args)
+	c.record(ctx, "exec", query, start, err)
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	c.record(ctx, "query", query, start, err)
+	return rows, err
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	p, ok := c.Conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	start := time.Now()
+	err := p.Ping(ctx)
+	c.record(ctx, "ping", "", start, err)
+	return err
+}
+
+// ResetSession 和CheckNamedValue直接交给驱动，连接池依赖它们判断坏连接、转换参数
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type stmt struct {
+	driver.Stmt
+	conn  *conn
+	query string
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	var res driver.Result
+	var err error
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values(args))
+	}
+	s.conn.record(ctx, "exec", s.query, start, err)
+	return res, err
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	var rows driver.Rows
+	var err error
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values(args))
+	}
+	s.conn.record(ctx, "query", s.query, start, err)
+	return rows, err
+}
+
+func (s *stmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if ch, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return ch.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// 只实现了旧接口的驱动不支持命名参数，按顺序传值
+func values(args []driver.NamedValue) []driver.Value {
+	vs := make([]driver.Value, len(args))
+	for i, a := range args {
+		vs[i] = a.Value
+	}
+	return vs
+}
+
+// driver.Tx的Commit和Rollback没有context，用BeginTx时的context把它们挂到同一个span下
+type txn struct {
+	driver.Tx
+	conn *conn
+	ctx  context.Context
+}
+
+func (t *txn) Commit() error {
+	start := time.Now()
+	err := t.Tx.Commit()
+	t.conn.record(t.ctx, "commit", "", start, err)
+	return err
+}
+
+func (t *txn) Rollback() error {
+	start := time.Now()
+	err := t.Tx.Rollback()
+	t.conn.record(t.ctx, "rollback", "", start, err)
+	return err
+}
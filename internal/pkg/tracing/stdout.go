@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// stdoutExporter 把每个span输出成一行JSON，本地调试时不需要collector
+type stdoutExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newStdoutExporter(w io.Writer) *stdoutExporter {
+	return &stdoutExporter{enc: json.NewEncoder(w)}
+}
+
+type stdoutEvent struct {
+	Name       string                 `json:"name"`
+	Time       time.Time              `json:"time"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+type stdoutSpan struct {
+	Name         string                 `json:"name"`
+	Service      string                 `json:"service,omitempty"`
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Kind         string                 `json:"kind"`
+	Start        time.Time              `json:"start"`
+	Duration     string                 `json:"duration"`
+	Status       string                 `json:"status"`
+	Description  string                 `json:"description,omitempty"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Events       []stdoutEvent          `json:"events,omitempty"`
+}
+
+func (e *stdoutExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range spans {
+		out := stdoutSpan{
+			Name:        s.Name(),
+			TraceID:     s.SpanContext().TraceID().String(),
+			SpanID:      s.SpanContext().SpanID().String(),
+			Kind:        s.SpanKind().String(),
+			Start:       s.StartTime(),
+			Duration:    s.EndTime().Sub(s.StartTime()).String(),
+			Status:      s.Status().Code.String(),
+			Description: s.Status().Description,
+			Attributes:  make(map[string]interface{}),
+		}
+		if s.Parent().HasSpanID() {
+			out.ParentSpanID = s.Parent().SpanID().String()
+		}
+		if v, ok := s.Resource().Set().Value(semconv.ServiceNameKey); ok {
+			out.Service = v.Emit()
+		}
+		for _, kv := range s.Attributes() {
+			out.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+		}
+		for _, ev := range s.Events() {
+			oe := stdoutEvent{Name: ev.Name, Time: ev.Time, Attributes: make(map[string]interface{})}
+			for _, kv := range ev.Attributes {
+				oe.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+			}
+			out.Events = append(out.Events, oe)
+		}
+		if err := e.enc.Encode(out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *stdoutExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
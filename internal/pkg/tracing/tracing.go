@@ -0,0 +1,79 @@
+// tracing 提供OpenTelemetry的链路追踪：HTTP请求经过gateway转发到gRPC，再到每一条SQL，都在同一条trace中
+// 上下游之间用W3C trace-context（traceparent头）传递，span可以通过OTLP导出到本地的collector，或者直接输出到stdout
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 本项目中创建span使用的instrumentation名字
+const instrumentationName = "go-grpc/internal/pkg/tracing"
+
+// 导出方式
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Options 是链路追踪的配置
+type Options struct {
+	// 上报的服务名
+	ServiceName string
+	// otlp或者stdout
+	Exporter string
+	// OTLP collector的gRPC地址，比如localhost:4317
+	Endpoint string
+	// 连接collector时不使用TLS，本地的collector一般都是明文的
+	Insecure bool
+	// 没有上游trace时的采样比例，上游有trace时跟随上游的决定
+	SampleRatio float64
+}
+
+// Init 创建全局的TracerProvider和W3C trace-context的propagator，返回的函数在退出时调用，把还没导出的span发送出去
+func Init(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	switch opts.Exporter {
+	case "", ExporterOTLP:
+		exp, err := otlptrace.New(ctx, newGRPCClient(opts.Endpoint, opts.Insecure))
+		if err != nil {
+			return nil, fmt.Errorf("创建OTLP exporter失败：%v", err)
+		}
+		exporter = exp
+	case ExporterStdout:
+		exporter = newStdoutExporter(os.Stdout)
+	default:
+		return nil, fmt.Errorf("不支持的trace exporter：%s", opts.Exporter)
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(opts.ServiceName))
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// TraceID 返回当前span的trace ID，没有trace时返回空字符串
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
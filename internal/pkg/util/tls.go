@@ -2,32 +2,28 @@ package util
 
 import (
 	"crypto/tls"
-	"io/ioutil"
+	"fmt"
 	"golang.org/x/net/http2"
 )
-// 获取TLS配置，读取server.key和server.pem
-func GetTLSConfig(certPemPath, certKeyPath string) (*tls.Config, error) {
-	// 保存公钥/私钥对
-	var certKeyPair *tls.Certificate
-	// 先读取两个文件
-	cert, err := ioutil.ReadFile(certPemPath)
-	if err != nil {
-		return nil, err
-	}
-	key, err := ioutil.ReadFile(certKeyPath)
-	if err != nil {
-		return nil, err
+
+// 获取TLS配置，证书通过getCertificate在每次握手时获取，这样证书文件更新之后不需要重启
+func GetTLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
+	//  NextProtoTLS是谈判期间的NPN/ALPN协议，用于HTTP/2的TLS设置
+	return &tls.Config{
+		GetCertificate: getCertificate,
+		NextProtos:     []string{http2.NextProtoTLS},
 	}
-	// 从PEM编码中解析出公钥/私钥对
-	pair, err := tls.X509KeyPair(cert, key)
-	if err != nil {
-		return nil, err
+}
+
+// 解析验证客户端证书的方式：none不要求证书，verify有证书时验证，require必须出示通过验证的证书
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "verify":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
 	}
-	
-	certKeyPair = &pair
-	//  NextProtoTLS是谈判期间的NPN/ALPN协议，用于HTTP/2的TLS设置
-	return &tls.Config {
-		Certificates: []tls.Certificate{*certKeyPair},
-		NextProtos: []string{http2.NextProtoTLS},
-	}, nil
-}
\ No newline at end of file
+	return tls.NoClientCert, fmt.Errorf("不支持的客户端证书验证方式：%s", mode)
+}
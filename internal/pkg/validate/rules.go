@@ -0,0 +1,188 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Rule 是某个字段上的一条规则，path是用.分隔的字段名，例如toDo.title
+// 除了Required以外，路径上的父消息为空时规则直接通过，父消息是否必填由Required单独声明
+type Rule struct {
+	path     string
+	required bool
+	check    func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string
+}
+
+// 按字段名或者JSON名找字段
+func field(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByJSONName(name); fd != nil {
+		return fd
+	}
+	return fields.ByName(protoreflect.Name(name))
+}
+
+// 检查path在消息中是否存在，除了最后一段以外都必须是消息字段
+func checkPath(md protoreflect.MessageDescriptor, path string) error {
+	segs := strings.Split(path, ".")
+	for i, seg := range segs {
+		fd := field(md, seg)
+		if fd == nil {
+			return fmt.Errorf("validate: %s中没有字段%s", md.FullName(), seg)
+		}
+		if i == len(segs)-1 {
+			return nil
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("validate: %s.%s不是消息字段，不能继续访问%s", md.FullName(), seg, segs[i+1])
+		}
+		md = fd.Message()
+	}
+	return nil
+}
+
+// 沿着path找到字段，Register时已经检查过路径；parentMissing表示路径上的某个父消息没有设置
+func lookup(m protoreflect.Message, path string) (v protoreflect.Value, fd protoreflect.FieldDescriptor, present, parentMissing bool) {
+	segs := strings.Split(path, ".")
+	for i, seg := range segs {
+		fd = field(m.Descriptor(), seg)
+		if fd == nil {
+			panic(fmt.Sprintf("validate: %s中没有字段%s", m.Descriptor().FullName(), seg))
+		}
+		if i == len(segs)-1 {
+			return m.Get(fd), fd, m.Has(fd), false
+		}
+		if !m.Has(fd) {
+			return protoreflect.Value{}, fd, false, true
+		}
+		m = m.Get(fd).Message()
+	}
+	return
+}
+
+func (r Rule) apply(m protoreflect.Message) string {
+	v, fd, present, parentMissing := lookup(m, r.path)
+	if parentMissing {
+		return ""
+	}
+	if r.required && !present {
+		return "不能为空"
+	}
+	if r.check == nil || !present {
+		return ""
+	}
+	return r.check(v, fd)
+}
+
+// Required 字段必须设置，消息字段不能为nil，标量字段不能是零值
+func Required(path string) Rule {
+	return Rule{path: path, required: true}
+}
+
+// NotBlank 字符串去掉首尾空白之后不能为空
+func NotBlank(path string) Rule {
+	return Rule{path: path, required: true, check: func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+		if len(strings.TrimSpace(v.String())) == 0 {
+			return "不能为空"
+		}
+		return ""
+	}}
+}
+
+// MaxLength 字符串最多max个字符（不是字节）
+func MaxLength(path string, max int) Rule {
+	return Rule{path: path, check: func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+		if utf8.RuneCountInString(v.String()) > max {
+			return fmt.Sprintf("不能超过%d个字符", max)
+		}
+		return ""
+	}}
+}
+
+// Range 整数字段必须在[min, max]之内，未设置（零值）时不检查
+func Range(path string, min, max int64) Rule {
+	return Rule{path: path, check: func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+		if n := v.Int(); n < min || n > max {
+			return fmt.Sprintf("必须在%d到%d之间", min, max)
+		}
+		return ""
+	}}
+}
+
+// Positive 整数字段必须大于0
+func Positive(path string) Rule {
+	return Rule{path: path, required: true, check: func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+		if v.Int() <= 0 {
+			return "必须大于0"
+		}
+		return ""
+	}}
+}
+
+// OneOfStrings 字符串字段只能是给定的值之一，未设置时不检查
+func OneOfStrings(path string, values ...string) Rule {
+	return Rule{path: path, check: func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+		for _, s := range values {
+			if v.String() == s {
+				return ""
+			}
+		}
+		return fmt.Sprintf("只能是%s之一", strings.Join(values, "、"))
+	}}
+}
+
+// DefinedEnum 枚举字段必须是定义过的值，并且不能是0（UNSPECIFIED）
+func DefinedEnum(path string) Rule {
+	return Rule{path: path, required: true, check: func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+		if fd.Enum().Values().ByNumber(v.Enum()) == nil {
+			return fmt.Sprintf("不支持的取值%d", v.Enum())
+		}
+		return ""
+	}}
+}
+
+// TimeBetween Timestamp字段必须是合法的时间并且在[from, to]之内
+func TimeBetween(path string, from, to time.Time) Rule {
+	return Rule{path: path, check: func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+		ts, ok := v.Message().Interface().(*timestamppb.Timestamp)
+		if !ok {
+			return "不是Timestamp类型"
+		}
+		if err := ts.CheckValid(); err != nil {
+			return "不是合法的时间"
+		}
+		if t := ts.AsTime(); t.Before(from) || t.After(to) {
+			return fmt.Sprintf("必须在%s到%s之间", from.Format(time.RFC3339), to.Format(time.RFC3339))
+		}
+		return ""
+	}}
+}
+
+// FieldMaskPaths FieldMask中只能出现给定的字段
+func FieldMaskPaths(path string, allowed ...string) Rule {
+	return Rule{path: path, check: func(v protoreflect.Value, fd protoreflect.FieldDescriptor) string {
+		mask, ok := v.Message().Interface().(*fieldmaskpb.FieldMask)
+		if !ok {
+			return "不是FieldMask类型"
+		}
+		for _, p := range mask.Paths {
+			found := false
+			for _, a := range allowed {
+				if p == a {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Sprintf("不支持更新的字段%s，只能是%s", p, strings.Join(allowed, "、"))
+			}
+		}
+		return ""
+	}}
+}
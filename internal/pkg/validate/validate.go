@@ -0,0 +1,102 @@
+// validate 按消息类型声明字段校验规则，由gRPC拦截器在调用handler之前统一检查
+// 校验失败时返回codes.InvalidArgument，并且在details中带上reason为VALIDATION_FAILED的ErrorInfo和google.rpc.BadRequest，列出所有违反规则的字段
+package validate
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"go-grpc/internal/pkg/errs"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var (
+	mu       sync.RWMutex
+	registry = make(map[protoreflect.FullName][]Rule)
+)
+
+// Register 为某个消息类型注册校验规则，一般在各个版本service包的init中调用
+// 规则的路径写错时直接panic，这样在启动时就能发现，而不是等到请求进来
+func Register(msg proto.Message, rules ...Rule) {
+	desc := msg.ProtoReflect().Descriptor()
+	for _, r := range rules {
+		if err := checkPath(desc, r.path); err != nil {
+			panic(err.Error())
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	name := desc.FullName()
+	registry[name] = append(registry[name], rules...)
+}
+
+// Violations 返回msg违反的所有规则，没有注册规则的消息总是通过
+func Violations(msg proto.Message) []*errdetails.BadRequest_FieldViolation {
+	mu.RLock()
+	rules := registry[msg.ProtoReflect().Descriptor().FullName()]
+	mu.RUnlock()
+	var violations []*errdetails.BadRequest_FieldViolation
+	m := msg.ProtoReflect()
+	for _, r := range rules {
+		if desc := r.apply(m); len(desc) > 0 {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: r.path, Description: desc})
+		}
+	}
+	return violations
+}
+
+// Validate 校验msg，不通过时返回带BadRequest详情的InvalidArgument错误
+func Validate(msg interface{}) error {
+	pm, ok := msg.(proto.Message)
+	if !ok {
+		return nil
+	}
+	violations := Violations(pm)
+	if len(violations) == 0 {
+		return nil
+	}
+	descs := make([]string, 0, len(violations))
+	for _, v := range violations {
+		descs = append(descs, v.Field+v.Description)
+	}
+	st := status.New(codes.InvalidArgument, "参数校验失败："+strings.Join(descs, "；"))
+	info := &errdetails.ErrorInfo{Reason: errs.ReasonValidationFailed, Domain: errs.Domain}
+	if withDetails, err := st.WithDetails(info, &errdetails.BadRequest{FieldViolations: violations}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// UnaryServerInterceptor 在调用handler之前校验请求
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := Validate(req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor 校验流式接口中客户端发来的每一条消息
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingStream{ServerStream: ss})
+	}
+}
+
+type validatingStream struct {
+	grpc.ServerStream
+}
+
+func (s *validatingStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return Validate(m)
+}
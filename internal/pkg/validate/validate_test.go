@@ -0,0 +1,135 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/errs"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestRules(t *testing.T) {
+	from := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := func(t time.Time) *timestamppb.Timestamp { return timestamppb.New(t) }
+	tests := []struct {
+		name string
+		rule Rule
+		msg  proto.Message
+		ok   bool
+	}{
+		{"Required有值", Required("toDo"), &v2.CreateRequest{ToDo: &v2.ToDo{}}, true},
+		{"Required消息为nil", Required("toDo"), &v2.CreateRequest{}, false},
+		{"Required标量为零值", Required("id"), &v2.ReadRequest{}, false},
+		{"NotBlank", NotBlank("toDo.title"), &v2.CreateRequest{ToDo: &v2.ToDo{Title: "买牛奶"}}, true},
+		{"NotBlank只有空白", NotBlank("toDo.title"), &v2.CreateRequest{ToDo: &v2.ToDo{Title: " \t"}}, false},
+		{"NotBlank父消息为nil时通过", NotBlank("toDo.title"), &v2.CreateRequest{}, true},
+		{"MaxLength按字符计算", MaxLength("title", 3), &v2.ToDo{Title: "买牛奶"}, true},
+		{"MaxLength超长", MaxLength("title", 3), &v2.ToDo{Title: "买牛奶了"}, false},
+		{"MaxLength未设置", MaxLength("title", 3), &v2.ToDo{}, true},
+		{"Range之内", Range("pageSize", 0, 100), &v2.ListRequest{PageSize: 100}, true},
+		{"Range超出", Range("pageSize", 0, 100), &v2.ListRequest{PageSize: 101}, false},
+		{"Range负数", Range("pageSize", 0, 100), &v2.ListRequest{PageSize: -1}, false},
+		{"Positive", Positive("id"), &v2.ReadRequest{Id: 1}, true},
+		{"Positive负数", Positive("id"), &v2.ReadRequest{Id: -1}, false},
+		{"Positive未设置", Positive("id"), &v2.ReadRequest{}, false},
+		{"OneOfStrings", OneOfStrings("q", "a", "b"), &v2.SearchRequest{Q: "b"}, true},
+		{"OneOfStrings不在列表中", OneOfStrings("q", "a", "b"), &v2.SearchRequest{Q: "c"}, false},
+		{"DefinedEnum", DefinedEnum("format"), &v2.ExportRequest{Format: v2.Format_FORMAT_CSV}, true},
+		{"DefinedEnum未设置", DefinedEnum("format"), &v2.ExportRequest{}, false},
+		{"DefinedEnum没有定义的值", DefinedEnum("format"), &v2.ExportRequest{Format: 99}, false},
+		{"TimeBetween", TimeBetween("reminder", from, to), &v2.ToDo{Reminder: ts(from)}, true},
+		{"TimeBetween之前", TimeBetween("reminder", from, to), &v2.ToDo{Reminder: ts(from.Add(-time.Second))}, false},
+		{"TimeBetween之后", TimeBetween("reminder", from, to), &v2.ToDo{Reminder: ts(to.Add(time.Second))}, false},
+		{"TimeBetween不合法", TimeBetween("reminder", from, to), &v2.ToDo{Reminder: &timestamppb.Timestamp{Nanos: -1}}, false},
+		{"TimeBetween未设置", TimeBetween("reminder", from, to), &v2.ToDo{}, true},
+		{"FieldMaskPaths", FieldMaskPaths("updateMask", "title"), &v2.UpdateRequest{UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}}}, true},
+		{"FieldMaskPaths不允许的字段", FieldMaskPaths("updateMask", "title"), &v2.UpdateRequest{UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"id"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPath(tt.msg.ProtoReflect().Descriptor(), tt.rule.path); err != nil {
+				t.Fatal(err)
+			}
+			desc := tt.rule.apply(tt.msg.ProtoReflect())
+			if (len(desc) == 0) != tt.ok {
+				t.Fatalf("%s：应该%v，结果是%q", tt.rule.path, tt.ok, desc)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	Register(&v2.CreateRequest{}, Required("toDo"), NotBlank("toDo.title"), MaxLength("toDo.title", 5))
+
+	if err := Validate(&v2.CreateRequest{ToDo: &v2.ToDo{Title: "买牛奶"}}); err != nil {
+		t.Fatalf("应该通过校验，结果是%v", err)
+	}
+	// 没有注册规则的消息和不是proto的值总是通过
+	if err := Validate(&v2.DeleteRequest{}); err != nil {
+		t.Fatalf("没有注册规则的消息应该通过，结果是%v", err)
+	}
+	if err := Validate("x"); err != nil {
+		t.Fatalf("不是proto消息时应该通过，结果是%v", err)
+	}
+
+	err := Validate(&v2.CreateRequest{})
+	st := status.Convert(err)
+	if st.Code() != codes.InvalidArgument {
+		t.Fatalf("应该返回InvalidArgument，结果是%v", err)
+	}
+	var reason string
+	var fields []string
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			reason = d.Reason
+		case *errdetails.BadRequest:
+			for _, v := range d.FieldViolations {
+				fields = append(fields, v.Field)
+			}
+		}
+	}
+	if reason != errs.ReasonValidationFailed || strings.Join(fields, ",") != "toDo" {
+		t.Fatalf("details不对：reason=%s fields=%v", reason, fields)
+	}
+
+	// 列出所有违反规则的字段
+	violations := Violations(&v2.CreateRequest{ToDo: &v2.ToDo{Title: "  牛奶牛奶牛奶 "}})
+	if len(violations) != 1 || violations[0].Field != "toDo.title" {
+		t.Fatalf("应该只有toDo.title超长，结果是%v", violations)
+	}
+}
+
+func TestRegisterBadPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"字段不存在", "toDo.titel"},
+		{"父字段不存在", "todo.title"},
+		{"标量字段下面还有路径", "toDo.title.x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("%s：注册时应该panic", tt.path)
+				}
+			}()
+			Register(&v2.UpdateRequest{}, MaxLength(tt.path, 1))
+		})
+	}
+	// panic的规则不能留在注册表中
+	if err := Validate(&v2.UpdateRequest{}); err != nil {
+		t.Fatalf("注册失败的规则不应该生效，结果是%v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package v1
+
+import (
+	v1 "go-grpc/api/server/v1"
+	v2 "go-grpc/api/server/v2"
+)
+
+// v1和v2的ToDo字段完全一样，但是是不同的类型，需要逐个字段转换
+
+func toV2ToDo(td *v1.ToDo) *v2.ToDo {
+	if td == nil {
+		return nil
+	}
+	return &v2.ToDo{Id: td.Id, Title: td.Title, Description: td.Description, Reminder: td.Reminder}
+}
+
+func toV1ToDo(td *v2.ToDo) *v1.ToDo {
+	if td == nil {
+		return nil
+	}
+	return &v1.ToDo{Id: td.Id, Title: td.Title, Description: td.Description, Reminder: td.Reminder}
+}
+
+func toV2Attachment(a *v1.Attachment) *v2.Attachment {
+	if a == nil {
+		return nil
+	}
+	return &v2.Attachment{Id: a.Id, ToDoId: a.ToDoId, Name: a.Name, ContentType: a.ContentType, Size: a.Size, CreatedAt: a.CreatedAt}
+}
+
+// ToV1Attachment 供server包中v1路径的HTTP接口使用
+func ToV1Attachment(a *v2.Attachment) *v1.Attachment {
+	if a == nil {
+		return nil
+	}
+	return &v1.Attachment{Id: a.Id, ToDoId: a.ToDoId, Name: a.Name, ContentType: a.ContentType, Size: a.Size, CreatedAt: a.CreatedAt}
+}
+
+// ToV1ImportResponse 供server包中v1路径的HTTP接口使用
+func ToV1ImportResponse(res *v2.ImportResponse) *v1.ImportResponse {
+	errors := make([]*v1.ImportRowError, 0, len(res.Errors))
+	for _, e := range res.Errors {
+		errors = append(errors, &v1.ImportRowError{Row: e.Row, Message: e.Message})
+	}
+	return &v1.ImportResponse{
+		Api:      apiVersion,
+		DryRun:   res.DryRun,
+		Total:    res.Total,
+		Imported: res.Imported,
+		Errors:   errors,
+	}
+}
@@ -0,0 +1,29 @@
+package v1
+
+import (
+	v1 "go-grpc/api/server/v1"
+	"go-grpc/internal/pkg/validate"
+	service "go-grpc/internal/service/server/v2"
+)
+
+// v1请求的校验规则，和v2保持一致，api字段仍然由checkAPI检查
+func init() {
+	validate.Register(&v1.CreateRequest{}, append([]validate.Rule{validate.Required("toDo")}, service.ToDoRules("toDo.")...)...)
+	validate.Register(&v1.ReadRequest{}, validate.Positive("id"))
+	// v1的Update是整体替换，所以所有字段都要满足ToDo的规则
+	validate.Register(&v1.UpdateRequest{}, append([]validate.Rule{validate.Required("toDo"), validate.Positive("toDo.id")}, service.ToDoRules("toDo.")...)...)
+	validate.Register(&v1.DeleteRequest{}, validate.Positive("id"))
+	validate.Register(&v1.SearchRequest{},
+		validate.NotBlank("q"),
+		validate.MaxLength("q", service.MaxQueryLength),
+		validate.Range("pageSize", 0, service.MaxSearchPageSize),
+	)
+	validate.Register(&v1.UploadAttachmentRequest{},
+		validate.Positive("info.toDoId"),
+		validate.NotBlank("info.name"),
+		validate.MaxLength("info.name", service.MaxAttachmentName),
+	)
+	validate.Register(&v1.DownloadAttachmentRequest{}, validate.Positive("id"))
+	validate.Register(&v1.ExportRequest{}, validate.DefinedEnum("format"))
+	validate.Register(&v1.ImportRequest{}, validate.DefinedEnum("options.format"))
+}
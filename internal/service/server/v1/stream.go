@@ -0,0 +1,124 @@
+package v1
+
+import (
+	v1 "go-grpc/api/server/v1"
+	v2 "go-grpc/api/server/v2"
+	"google.golang.org/grpc"
+)
+
+// 流式接口的适配：把v1的stream包装成v2的stream交给v2的实现，收发消息时做转换
+// 客户端流的api字段在第一条消息里，所以在第一次Recv时检查
+
+func (s *ToDoServiceServer) UploadAttachment(stream v1.ToDoService_UploadAttachmentServer) error {
+	return s.v2.UploadAttachment(&uploadAttachmentStream{ServerStream: stream, v1: stream, s: s})
+}
+
+func (s *ToDoServiceServer) DownloadAttachment(req *v1.DownloadAttachmentRequest, stream v1.ToDoService_DownloadAttachmentServer) error {
+	if err := s.checkAPI(req.Api); err != nil {
+		return err
+	}
+	return s.v2.DownloadAttachment(&v2.DownloadAttachmentRequest{Id: req.Id}, &downloadAttachmentStream{ServerStream: stream, v1: stream})
+}
+
+func (s *ToDoServiceServer) Export(req *v1.ExportRequest, stream v1.ToDoService_ExportServer) error {
+	if err := s.checkAPI(req.Api); err != nil {
+		return err
+	}
+	return s.v2.Export(&v2.ExportRequest{Format: v2.Format(req.Format)}, &exportStream{ServerStream: stream, v1: stream})
+}
+
+func (s *ToDoServiceServer) Import(stream v1.ToDoService_ImportServer) error {
+	return s.v2.Import(&importStream{ServerStream: stream, v1: stream, s: s})
+}
+
+type uploadAttachmentStream struct {
+	grpc.ServerStream
+	v1       v1.ToDoService_UploadAttachmentServer
+	s        *ToDoServiceServer
+	received bool
+}
+
+func (u *uploadAttachmentStream) Recv() (*v2.UploadAttachmentRequest, error) {
+	req, err := u.v1.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if !u.received {
+		u.received = true
+		if err := u.s.checkAPI(req.Api); err != nil {
+			return nil, err
+		}
+	}
+	switch data := req.Data.(type) {
+	case *v1.UploadAttachmentRequest_Info:
+		return &v2.UploadAttachmentRequest{Data: &v2.UploadAttachmentRequest_Info{Info: toV2Attachment(data.Info)}}, nil
+	case *v1.UploadAttachmentRequest_Chunk:
+		return &v2.UploadAttachmentRequest{Data: &v2.UploadAttachmentRequest_Chunk{Chunk: data.Chunk}}, nil
+	}
+	return &v2.UploadAttachmentRequest{}, nil
+}
+
+func (u *uploadAttachmentStream) SendAndClose(res *v2.UploadAttachmentResponse) error {
+	return u.v1.SendAndClose(&v1.UploadAttachmentResponse{Api: apiVersion, Attachment: ToV1Attachment(res.Attachment)})
+}
+
+type downloadAttachmentStream struct {
+	grpc.ServerStream
+	v1 v1.ToDoService_DownloadAttachmentServer
+}
+
+func (d *downloadAttachmentStream) Send(res *v2.DownloadAttachmentResponse) error {
+	out := &v1.DownloadAttachmentResponse{Api: apiVersion}
+	switch data := res.Data.(type) {
+	case *v2.DownloadAttachmentResponse_Info:
+		out.Data = &v1.DownloadAttachmentResponse_Info{Info: ToV1Attachment(data.Info)}
+	case *v2.DownloadAttachmentResponse_Chunk:
+		out.Data = &v1.DownloadAttachmentResponse_Chunk{Chunk: data.Chunk}
+	}
+	return d.v1.Send(out)
+}
+
+type exportStream struct {
+	grpc.ServerStream
+	v1 v1.ToDoService_ExportServer
+}
+
+func (e *exportStream) Send(res *v2.ExportResponse) error {
+	return e.v1.Send(&v1.ExportResponse{Api: apiVersion, Chunk: res.Chunk})
+}
+
+type importStream struct {
+	grpc.ServerStream
+	v1       v1.ToDoService_ImportServer
+	s        *ToDoServiceServer
+	received bool
+}
+
+func (i *importStream) Recv() (*v2.ImportRequest, error) {
+	req, err := i.v1.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if !i.received {
+		i.received = true
+		if err := i.s.checkAPI(req.Api); err != nil {
+			return nil, err
+		}
+	}
+	switch data := req.Data.(type) {
+	case *v1.ImportRequest_Options:
+		opts := &v2.ImportOptions{}
+		if data.Options != nil {
+			opts.Format = v2.Format(data.Options.Format)
+			opts.DryRun = data.Options.DryRun
+		}
+		return &v2.ImportRequest{Data: &v2.ImportRequest_Options{Options: opts}}, nil
+	case *v1.ImportRequest_Chunk:
+		return &v2.ImportRequest{Data: &v2.ImportRequest_Chunk{Chunk: data.Chunk}}, nil
+	}
+	return &v2.ImportRequest{}, nil
+}
+
+func (i *importStream) SendAndClose(res *v2.ImportResponse) error {
+	return i.v1.SendAndClose(ToV1ImportResponse(res))
+}
@@ -1,132 +1,71 @@
 package v1
 
 import (
-	"database/sql"
-	v1 "go-grpc/api/server/v1"
-	"github.com/golang/protobuf/ptypes"
-	"google.golang.org/grpc/status"
-	"google.golang.org/grpc/codes"
 	"context"
 	"fmt"
-	"time"
+	v1 "go-grpc/api/server/v1"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/errs"
+	service "go-grpc/internal/service/server/v2"
+	"google.golang.org/grpc/codes"
 )
 
 const (
 	apiVersion = "v1"
 )
 
+// ToDoServiceServer 是v1接口的适配层，只负责检查api字段和转换消息，具体的逻辑都在v2的实现中
 type ToDoServiceServer struct {
 	v1.UnimplementedToDoServiceServer
-	db *sql.DB
+	v2 *service.ToDoServiceServer
 }
 
-func NewToDoServiceServer(db *sql.DB) *ToDoServiceServer {
-	return &ToDoServiceServer{db: db}
+func NewToDoServiceServer(v2API *service.ToDoServiceServer) *ToDoServiceServer {
+	return &ToDoServiceServer{v2: v2API}
 }
 
 func (s *ToDoServiceServer) checkAPI(api string) error {
 	if len(api) > 0 {
 		if apiVersion != api {
-			return status.Error(codes.Unimplemented, fmt.Sprintf("API版本不适配f，本版本为'%s'，调用版本为'%s'", apiVersion, api))
+			return errs.New(codes.Unimplemented, errs.ReasonAPIVersionMismatch, fmt.Sprintf("API版本不适配f，本版本为'%s'，调用版本为'%s'", apiVersion, api), "supported", apiVersion, "requested", api)
 		}
 	}
 	return nil
 }
 
-func (s *ToDoServiceServer) connect(ctx context.Context) (*sql.Conn, error) {
-	c, err := s.db.Conn(ctx)
-	if err != nil {
-		return nil, status.Error(codes.Unknown, "连接数据库失败" + err.Error())
-	}
-	return c, nil
-}
-
 func (s *ToDoServiceServer) Create(ctx context.Context, req *v1.CreateRequest) (*v1.CreateResponse, error) {
 	if err := s.checkAPI(req.Api); err != nil {
 		return nil, err
 	}
-	c, err := s.connect(ctx)
+	res, err := s.v2.Create(ctx, &v2.CreateRequest{ToDo: toV2ToDo(req.ToDo)})
 	if err != nil {
 		return nil, err
 	}
-	defer c.Close()
-	reminder, err := ptypes.Timestamp(req.ToDo.Reminder)
-	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, "参数错误" + err.Error())
-	}
-	res, err := c.ExecContext(ctx, "INSERT INTO ToDo(`Title`, `Description`, `Reminder`) VALUES(?, ?, ?)", req.ToDo.Title, req.ToDo.Description, reminder)
-	if err != nil {
-		return nil, status.Error(codes.Unknown, "添加ToDo失败" + err.Error())
-	} 
-	id, err := res.LastInsertId()
-	if err != nil {
-		return nil, status.Error(codes.Unknown, "获取最近ID失败" + err.Error())
-	}
-	return &v1.CreateResponse{Api: apiVersion, Id: id}, nil
+	return &v1.CreateResponse{Api: apiVersion, Id: res.ToDo.Id}, nil
 }
 
 func (s *ToDoServiceServer) Read(ctx context.Context, req *v1.ReadRequest) (*v1.ReadResponse, error) {
 	if err := s.checkAPI(req.Api); err != nil {
 		return nil, err
 	}
-	c, err := s.connect(ctx)
+	res, err := s.v2.Read(ctx, &v2.ReadRequest{Id: req.Id})
 	if err != nil {
 		return nil, err
 	}
-	defer c.Close()
-
-	rows, err := c.QueryContext(ctx, "SELECT  `ID`, `Title`, `Description`, `Reminder` FROM ToDo WHERE `ID`=?", req.Id)
-	if !rows.Next() {
-		if err := rows.Err(); err != nil {
-			return nil, status.Error(codes.Unknown, "获取数据失败" + err.Error())
-		}
-		return nil, status.Error(codes.NotFound, fmt.Sprintf("ID='%d'找不到", req.Id))
-	}
-	defer rows.Close()
-
-	var td v1.ToDo
-	var reminder time.Time
-	if err := rows.Scan(&td.Id, &td.Title, &td.Description, &reminder); err != nil {
-		return nil, status.Error(codes.Unknown, "查找数据失败" + err.Error())
-	}
-	td.Reminder, err = ptypes.TimestampProto(reminder)
-	if err != nil {
-		return nil, status.Error(codes.Unknown, fmt.Sprintf("reminder 格式无效：%v", err))
-	}
-
-	if rows.Next() {
-		return nil, status.Error(codes.Unknown, fmt.Sprintf("查到多条数据ID：%d", req.Id))
-	}
-	return &v1.ReadResponse{Api: apiVersion, ToDo: &td}, nil
+	return &v1.ReadResponse{Api: apiVersion, ToDo: toV1ToDo(res.ToDo)}, nil
 }
 
+// v1的Update总是整体替换，对应v2中不带updateMask的Update
 func (s *ToDoServiceServer) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateResponse, error) {
 	if err := s.checkAPI(req.Api); err != nil {
 		return nil, err
 	}
-	c, err := s.connect(ctx)
-	if err != nil {
+	if _, err := s.v2.Update(ctx, &v2.UpdateRequest{ToDo: toV2ToDo(req.ToDo)}); err != nil {
 		return nil, err
 	}
-	defer c.Close()
-	reminder, err := ptypes.Timestamp(req.ToDo.Reminder)
-	if err != nil {
-		return nil, status.Error(codes.InvalidArgument, "reminder参数无效")
-	}
-	res, err := c.ExecContext(ctx, "UPDATE ToDo SET `Title`=?, `Description`=?, `Reminder`=? WHERE `ID`=?", req.ToDo.Title, req.ToDo.Description, reminder, req.ToDo.Id)
-	if err != nil {
-		return nil, status.Error(codes.Unknown, "更新失败" + err.Error())
-	}
-	rows, err := res.RowsAffected()
-	if err != nil {
-		return nil, status.Error(codes.Unknown, "行更新失败" + err.Error())
-	}
-	if rows == 0 {
-		return nil, status.Error(codes.NotFound, fmt.Sprintf("ID=‘%d’找不到", req.ToDo.Id))
-	}
-	return &v1.UpdateResponse {
-		Api: apiVersion,
-		Updated: rows,
+	return &v1.UpdateResponse{
+		Api:     apiVersion,
+		Updated: 1,
 	}, nil
 }
 
@@ -134,61 +73,62 @@ func (s *ToDoServiceServer) Delete(ctx context.Context, req *v1.DeleteRequest) (
 	if err := s.checkAPI(req.Api); err != nil {
 		return nil, err
 	}
-	c, err := s.connect(ctx)
-	if err != nil {
+	if _, err := s.v2.Delete(ctx, &v2.DeleteRequest{Id: req.Id}); err != nil {
 		return nil, err
 	}
-	defer c.Close()
-	res, err := c.ExecContext(ctx, "DELETE FROM ToDo WHERE `ID`=?", req.Id)
-	if err != nil {
-		return nil, status.Error(codes.Unknown, "删除失败" + err.Error())
-	}
-	rows, err := res.RowsAffected()
-	if err != nil {
-		return nil, status.Error(codes.Unknown, "行删除失败" + err.Error())
-	}
-	if rows == 0 {
-		return nil, status.Error(codes.NotFound, fmt.Sprintf("ID='%d'找不到", req.Id))
-	}
-	return &v1.DeleteResponse {
-		Api: req.Api,
-		Deleted: rows,
+	return &v1.DeleteResponse{
+		Api:     req.Api,
+		Deleted: 1,
 	}, nil
 }
 
+// v1的ReadAll不分页，这里把v2的List逐页读完
 func (s *ToDoServiceServer) ReadAll(ctx context.Context, req *v1.ReadAllRequest) (*v1.ReadAllResponse, error) {
 	if err := s.checkAPI(req.Api); err != nil {
 		return nil, err
 	}
-	c, err := s.connect(ctx)
-	if err != nil {
-		return nil ,err
-	}
-	defer c.Close()
-	rows, err := c.QueryContext(ctx, "SELECT `ID`, `Title`, `Description`, `Reminder` FROM ToDo")
-	if err != nil {
-		return nil, status.Error(codes.Unknown, "查询失败" + err.Error())
-	}
-	defer rows.Close()
-	var reminder time.Time
 	list := make([]*v1.ToDo, 0)
-	for rows.Next() {
-		td := new(v1.ToDo)
-		if err := rows.Scan(&td.Id, &td.Title, &td.Description, &reminder); err != nil {
-			return nil, status.Error(codes.Unknown, "查询失败" + err.Error())
-		}
-		td.Reminder, err = ptypes.TimestampProto(reminder)
+	listReq := &v2.ListRequest{PageSize: 1000}
+	for {
+		res, err := s.v2.List(ctx, listReq)
 		if err != nil {
-			return nil, status.Error(codes.Unknown, "reminder 格式无效：" + err.Error())
+			return nil, err
 		}
-		list = append(list, td)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, status.Error(codes.Unknown, "获取数据失败：" + err.Error())
+		for _, td := range res.ToDos {
+			list = append(list, toV1ToDo(td))
+		}
+		if len(res.NextPageToken) == 0 {
+			break
+		}
+		listReq.PageToken = res.NextPageToken
 	}
-	return &v1.ReadAllResponse {
-		Api: apiVersion,
-		ToDos: list, 
+	return &v1.ReadAllResponse{
+		Api:   apiVersion,
+		ToDos: list,
 	}, nil
 }
 
+func (s *ToDoServiceServer) Search(ctx context.Context, req *v1.SearchRequest) (*v1.SearchResponse, error) {
+	if err := s.checkAPI(req.Api); err != nil {
+		return nil, err
+	}
+	res, err := s.v2.Search(ctx, &v2.SearchRequest{Q: req.Q, PageSize: req.PageSize, PageToken: req.PageToken})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*v1.SearchResult, 0, len(res.Results))
+	for _, r := range res.Results {
+		results = append(results, &v1.SearchResult{
+			ToDo:               toV1ToDo(r.ToDo),
+			Score:              r.Score,
+			TitleSnippet:       r.TitleSnippet,
+			DescriptionSnippet: r.DescriptionSnippet,
+		})
+	}
+	return &v1.SearchResponse{
+		Api:           apiVersion,
+		Results:       results,
+		NextPageToken: res.NextPageToken,
+		TotalSize:     res.TotalSize,
+	}, nil
+}
@@ -0,0 +1,220 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/audit"
+	"go-grpc/internal/pkg/auth"
+	"go-grpc/internal/pkg/errs"
+	"google.golang.org/grpc/codes"
+)
+
+// APIKeyServiceServer 管理API key，同时实现auth.APIKeyStore供认证时查找
+type APIKeyServiceServer struct {
+	v2.UnimplementedAPIKeyServiceServer
+	db *sql.DB
+}
+
+func NewAPIKeyServiceServer(db *sql.DB) *APIKeyServiceServer {
+	return &APIKeyServiceServer{db: db}
+}
+
+const apiKeyColumns = "`ID`, `Name`, `Prefix`, `Scopes`, `CreatedAt`, `RotatedAt`, `RevokedAt`"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*v2.APIKey, error) {
+	var k v2.APIKey
+	var scopes string
+	var createdAt time.Time
+	var rotatedAt, revokedAt sql.NullTime
+	if err := row.Scan(&k.Id, &k.Name, &k.Prefix, &scopes, &createdAt, &rotatedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	k.CreatedAt, _ = ptypes.TimestampProto(createdAt)
+	if rotatedAt.Valid {
+		k.RotatedAt, _ = ptypes.TimestampProto(rotatedAt.Time)
+	}
+	if revokedAt.Valid {
+		k.RevokedAt, _ = ptypes.TimestampProto(revokedAt.Time)
+	}
+	return &k, nil
+}
+
+// 只能授予已知的scope，重复的去掉
+func checkScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	var out []string
+	for _, s := range scopes {
+		known := false
+		for _, k := range auth.Scopes {
+			known = known || s == k
+		}
+		if !known {
+			return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, fmt.Sprintf("不支持的scope：%s，可用的有%s", s, strings.Join(auth.Scopes, "、")), "field", "scopes")
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, "至少需要一个scope", "field", "scopes")
+	}
+	return out, nil
+}
+
+// 审计日志中API key的资源名，记录的哈希不包含密钥
+func apiKeyResource(id int64) string {
+	return "apiKeys/" + strconv.FormatInt(id, 10)
+}
+
+func apiKeyNotFound(id int64) error {
+	return errs.NotFound(errs.ReasonAPIKeyNotFound, fmt.Sprintf("API key ID='%d'找不到", id), "id", strconv.FormatInt(id, 10))
+}
+
+func (s *APIKeyServiceServer) CreateAPIKey(ctx context.Context, req *v2.CreateAPIKeyRequest) (*v2.CreateAPIKeyResponse, error) {
+	scopes, err := checkScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	secret, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return nil, errs.Internal("生成API key失败", err)
+	}
+	now := time.Now().In(time.UTC)
+	res, err := s.db.ExecContext(ctx, "INSERT INTO APIKey(`Name`, `Prefix`, `Hash`, `Scopes`, `CreatedAt`) VALUES(?, ?, ?, ?, ?)",
+		req.Name, prefix, hash, strings.Join(scopes, " "), now)
+	if err != nil {
+		return nil, errs.Wrap("添加API key失败", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, errs.Wrap("获取最近ID失败", err)
+	}
+	createdAt, _ := ptypes.TimestampProto(now)
+	k := &v2.APIKey{Id: id, Name: req.Name, Scopes: scopes, Prefix: prefix, CreatedAt: createdAt}
+	audit.Record(ctx, apiKeyResource(id), "", audit.Hash(k))
+	return &v2.CreateAPIKeyResponse{ApiKey: k, Secret: secret}, nil
+}
+
+func (s *APIKeyServiceServer) ListAPIKeys(ctx context.Context, req *v2.ListAPIKeysRequest) (*v2.ListAPIKeysResponse, error) {
+	query := "SELECT " + apiKeyColumns + " FROM APIKey"
+	if !req.IncludeRevoked {
+		query += " WHERE `RevokedAt` IS NULL"
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY `ID`")
+	if err != nil {
+		return nil, errs.Wrap("查询API key失败", err)
+	}
+	defer rows.Close()
+	res := &v2.ListAPIKeysResponse{ApiKeys: []*v2.APIKey{}}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, errs.Wrap("读取API key失败", err)
+		}
+		res.ApiKeys = append(res.ApiKeys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap("读取API key失败", err)
+	}
+	return res, nil
+}
+
+// 在事务中锁住key再修改，避免同时轮换和吊销
+func (s *APIKeyServiceServer) lockAPIKey(ctx context.Context, tx *sql.Tx, id int64) (*v2.APIKey, error) {
+	k, err := scanAPIKey(tx.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM APIKey WHERE `ID`=? FOR UPDATE", id))
+	if err == sql.ErrNoRows {
+		return nil, apiKeyNotFound(id)
+	}
+	if err != nil {
+		return nil, errs.Wrap("查询API key失败", err)
+	}
+	return k, nil
+}
+
+// RotateAPIKey 换一个新的key，ID、名字和scope不变，旧的key立即失效
+func (s *APIKeyServiceServer) RotateAPIKey(ctx context.Context, req *v2.RotateAPIKeyRequest) (*v2.RotateAPIKeyResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap("开启事务失败", err)
+	}
+	defer tx.Rollback()
+	k, err := s.lockAPIKey(ctx, tx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if k.RevokedAt != nil {
+		return nil, errs.New(codes.FailedPrecondition, errs.ReasonAPIKeyRevoked, fmt.Sprintf("API key ID='%d'已经吊销", req.Id), "id", strconv.FormatInt(req.Id, 10))
+	}
+	before := audit.Hash(k)
+	secret, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return nil, errs.Internal("生成API key失败", err)
+	}
+	now := time.Now().In(time.UTC)
+	if _, err := tx.ExecContext(ctx, "UPDATE APIKey SET `Prefix`=?, `Hash`=?, `RotatedAt`=? WHERE `ID`=?", prefix, hash, now, req.Id); err != nil {
+		return nil, errs.Wrap("轮换API key失败", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errs.Wrap("提交事务失败", err)
+	}
+	k.Prefix = prefix
+	k.RotatedAt, _ = ptypes.TimestampProto(now)
+	audit.Record(ctx, apiKeyResource(k.Id), before, audit.Hash(k))
+	return &v2.RotateAPIKeyResponse{ApiKey: k, Secret: secret}, nil
+}
+
+// RevokeAPIKey 吊销key，已经吊销的直接返回
+func (s *APIKeyServiceServer) RevokeAPIKey(ctx context.Context, req *v2.RevokeAPIKeyRequest) (*v2.RevokeAPIKeyResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap("开启事务失败", err)
+	}
+	defer tx.Rollback()
+	k, err := s.lockAPIKey(ctx, tx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if k.RevokedAt != nil {
+		return &v2.RevokeAPIKeyResponse{ApiKey: k}, nil
+	}
+	before := audit.Hash(k)
+	now := time.Now().In(time.UTC)
+	if _, err := tx.ExecContext(ctx, "UPDATE APIKey SET `RevokedAt`=? WHERE `ID`=?", now, req.Id); err != nil {
+		return nil, errs.Wrap("吊销API key失败", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errs.Wrap("提交事务失败", err)
+	}
+	k.RevokedAt, _ = ptypes.TimestampProto(now)
+	audit.Record(ctx, apiKeyResource(k.Id), before, audit.Hash(k))
+	return &v2.RevokeAPIKeyResponse{ApiKey: k}, nil
+}
+
+// LookupAPIKey 实现auth.APIKeyStore，已吊销的key和不存在的一样
+func (s *APIKeyServiceServer) LookupAPIKey(ctx context.Context, prefix string) (*auth.StoredAPIKey, error) {
+	var k auth.StoredAPIKey
+	var scopes string
+	err := s.db.QueryRowContext(ctx, "SELECT `ID`, `Hash`, `Scopes` FROM APIKey WHERE `Prefix`=? AND `RevokedAt` IS NULL", prefix).
+		Scan(&k.ID, &k.Hash, &scopes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errs.Wrap("查询API key失败", err)
+	}
+	k.Scopes = strings.Fields(scopes)
+	return &k, nil
+}
@@ -0,0 +1,259 @@
+package v2
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/ptypes"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/audit"
+	"go-grpc/internal/pkg/blob"
+	"go-grpc/internal/pkg/errs"
+	"go-grpc/internal/pkg/logging"
+	"go.uber.org/zap"
+)
+
+const (
+	// 单个附件的最大字节数
+	maxAttachmentSize = 32 << 20
+	// 下载时每条消息的分块大小
+	downloadChunkSize = 32 << 10
+)
+
+// 审计日志中附件的资源名
+func attachmentResource(id int64) string {
+	return "attachments/" + strconv.FormatInt(id, 10)
+}
+
+// 检查ToDo是否存在，附件必须挂在一个存在的ToDo下面
+// forUpdate时锁住这一行，和Delete拿的是同一把锁，提交之前ToDo不会被删除
+func (s *ToDoServiceServer) checkToDo(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}, id int64, forUpdate bool) error {
+	query := "SELECT `ID` FROM ToDo WHERE `ID`=?"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var found int64
+	err := q.QueryRowContext(ctx, query, id).Scan(&found)
+	if err == sql.ErrNoRows {
+		return errs.NotFound(errs.ReasonToDoNotFound, fmt.Sprintf("ID='%d'找不到", id), "id", strconv.FormatInt(id, 10))
+	}
+	if err != nil {
+		return errs.Wrap("查询ToDo失败", err)
+	}
+	return nil
+}
+
+// 附件内容的key，同一个ToDo同一时刻的两次上传也不能相同
+func attachmentKey(toDoID int64, now time.Time) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("todo/%d/%d-%s", toDoID, now.UnixNano(), hex.EncodeToString(b)), nil
+}
+
+// SaveAttachment 把r的内容写入BlobStore并记录附件元数据，gRPC的流式上传和HTTP的multipart上传都走这里
+// HTTP的上传不经过校验拦截器，所以附件名的规则在这里再检查一次
+func (s *ToDoServiceServer) SaveAttachment(ctx context.Context, toDoID int64, name, contentType string, r io.Reader) (*v2.Attachment, error) {
+	if len(strings.TrimSpace(name)) == 0 {
+		return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, "附件名不能为空", "field", "name")
+	}
+	if utf8.RuneCountInString(name) > MaxAttachmentName {
+		return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, fmt.Sprintf("附件名不能超过%d个字符", MaxAttachmentName), "field", "name")
+	}
+	// 上传之前先检查一次，ToDo不存在时不用读取内容
+	if err := s.checkToDo(ctx, s.db, toDoID, false); err != nil {
+		return nil, err
+	}
+	now := time.Now().In(time.UTC)
+	key, err := attachmentKey(toDoID, now)
+	if err != nil {
+		return nil, errs.Internal("生成附件key失败", err)
+	}
+	// 多读一个字节，用来判断是否超过了大小限制
+	size, err := s.blobs.Put(ctx, key, io.LimitReader(r, maxAttachmentSize+1))
+	if err != nil {
+		return nil, errs.Wrap("保存附件失败", err)
+	}
+	if size > maxAttachmentSize {
+		s.blobs.Delete(ctx, key)
+		return nil, errs.InvalidArgument(errs.ReasonAttachmentTooLarge, fmt.Sprintf("附件超过大小限制%d字节", maxAttachmentSize), "limit", strconv.Itoa(maxAttachmentSize))
+	}
+	id, err := s.insertAttachment(ctx, toDoID, name, contentType, size, key, now)
+	if err != nil {
+		s.blobs.Delete(ctx, key)
+		return nil, err
+	}
+	createdAt, _ := ptypes.TimestampProto(now)
+	a := &v2.Attachment{
+		Id:          id,
+		ToDoId:      toDoID,
+		Name:        name,
+		ContentType: contentType,
+		Size:        size,
+		CreatedAt:   createdAt,
+	}
+	audit.Record(ctx, attachmentResource(id), "", audit.Hash(a))
+	return a, nil
+}
+
+// 在事务中锁住ToDo再插入附件记录，上传期间ToDo被删除时返回NotFound，不会留下没有ToDo的附件
+func (s *ToDoServiceServer) insertAttachment(ctx context.Context, toDoID int64, name, contentType string, size int64, key string, now time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, errs.Wrap("开启事务失败", err)
+	}
+	defer tx.Rollback()
+	if err := s.checkToDo(ctx, tx, toDoID, true); err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, "INSERT INTO Attachment(`ToDoID`, `Name`, `ContentType`, `Size`, `BlobKey`, `CreatedAt`) VALUES(?, ?, ?, ?, ?, ?)",
+		toDoID, name, contentType, size, key, now)
+	if err != nil {
+		return 0, errs.Wrap("添加附件失败", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errs.Wrap("获取最近ID失败", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, errs.Wrap("提交事务失败", err)
+	}
+	return id, nil
+}
+
+// 在删除ToDo的事务中删除它的附件，返回删除的附件和它们的BlobKey，提交之后再删除内容
+func (s *ToDoServiceServer) deleteAttachments(ctx context.Context, tx *sql.Tx, toDoID int64) ([]*v2.Attachment, []string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT `ID`, `ToDoID`, `Name`, `ContentType`, `Size`, `BlobKey`, `CreatedAt` FROM Attachment WHERE `ToDoID`=? FOR UPDATE", toDoID)
+	if err != nil {
+		return nil, nil, errs.Wrap("查找附件失败", err)
+	}
+	defer rows.Close()
+	var list []*v2.Attachment
+	var keys []string
+	for rows.Next() {
+		a := new(v2.Attachment)
+		var key string
+		var createdAt time.Time
+		if err := rows.Scan(&a.Id, &a.ToDoId, &a.Name, &a.ContentType, &a.Size, &key, &createdAt); err != nil {
+			return nil, nil, errs.Wrap("查找附件失败", err)
+		}
+		a.CreatedAt, _ = ptypes.TimestampProto(createdAt)
+		list = append(list, a)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, errs.Wrap("获取附件失败", err)
+	}
+	rows.Close()
+	if len(list) == 0 {
+		return nil, nil, nil
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM Attachment WHERE `ToDoID`=?", toDoID); err != nil {
+		return nil, nil, errs.Wrap("删除附件失败", err)
+	}
+	return list, keys, nil
+}
+
+// 删除附件的内容，数据库中的记录已经删除了，失败时只记录日志，留下的文件不会再被访问到
+func (s *ToDoServiceServer) removeBlobs(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			logging.FromContext(ctx).Warn("删除附件内容失败", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// OpenAttachment 查找附件元数据并打开内容，调用方负责Close
+func (s *ToDoServiceServer) OpenAttachment(ctx context.Context, id int64) (*v2.Attachment, blob.Object, error) {
+	var a v2.Attachment
+	var key string
+	var createdAt time.Time
+	err := s.db.QueryRowContext(ctx, "SELECT `ID`, `ToDoID`, `Name`, `ContentType`, `Size`, `BlobKey`, `CreatedAt` FROM Attachment WHERE `ID`=?", id).
+		Scan(&a.Id, &a.ToDoId, &a.Name, &a.ContentType, &a.Size, &key, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, errs.NotFound(errs.ReasonAttachmentNotFound, fmt.Sprintf("附件ID='%d'找不到", id), "id", strconv.FormatInt(id, 10))
+	}
+	if err != nil {
+		return nil, nil, errs.Wrap("查找附件失败", err)
+	}
+	a.CreatedAt, err = ptypes.TimestampProto(createdAt)
+	if err != nil {
+		return nil, nil, errs.Internal("createdAt 格式无效", err)
+	}
+	obj, err := s.blobs.Open(ctx, key)
+	if err == blob.ErrNotFound {
+		return nil, nil, errs.NotFound(errs.ReasonAttachmentNotFound, fmt.Sprintf("附件ID='%d'的内容找不到", id), "id", strconv.FormatInt(id, 10))
+	}
+	if err != nil {
+		return nil, nil, errs.Wrap("读取附件失败", err)
+	}
+	return &a, obj, nil
+}
+
+func (s *ToDoServiceServer) UploadAttachment(stream v2.ToDoService_UploadAttachmentServer) error {
+	// 第一条消息必须是附件的info
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return errs.InvalidArgument(errs.ReasonInvalidArgument, "第一条消息必须是附件信息")
+	}
+	if err != nil {
+		return errs.Wrap("读取附件信息失败", err)
+	}
+	info := req.GetInfo()
+	if info == nil {
+		return errs.InvalidArgument(errs.ReasonInvalidArgument, "第一条消息必须是附件信息")
+	}
+	r := &chunkReader{next: func() ([]byte, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if req.GetInfo() != nil {
+			return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, "附件信息只能出现在第一条消息")
+		}
+		return req.GetChunk(), nil
+	}}
+	a, err := s.SaveAttachment(stream.Context(), info.ToDoId, info.Name, info.ContentType, r)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(&v2.UploadAttachmentResponse{Attachment: a})
+}
+
+func (s *ToDoServiceServer) DownloadAttachment(req *v2.DownloadAttachmentRequest, stream v2.ToDoService_DownloadAttachmentServer) error {
+	a, obj, err := s.OpenAttachment(stream.Context(), req.Id)
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+	if err := stream.Send(&v2.DownloadAttachmentResponse{Data: &v2.DownloadAttachmentResponse_Info{Info: a}}); err != nil {
+		return err
+	}
+	buf := make([]byte, downloadChunkSize)
+	for {
+		n, err := obj.Read(buf)
+		if n > 0 {
+			chunk := &v2.DownloadAttachmentResponse_Chunk{Chunk: buf[:n]}
+			if err := stream.Send(&v2.DownloadAttachmentResponse{Data: chunk}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errs.Wrap("读取附件失败", err)
+		}
+	}
+}
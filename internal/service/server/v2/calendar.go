@@ -0,0 +1,39 @@
+package v2
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/errs"
+	"go-grpc/internal/pkg/exchange"
+	"google.golang.org/protobuf/proto"
+)
+
+// CalendarFeed 把owner创建的ToDo渲染成iCalendar，每个有reminder的ToDo都带一个VALARM
+// 返回的etag只和ToDo的内容有关，DTSTAMP每次渲染都不一样，所以不能直接对body做hash
+func (s *ToDoServiceServer) CalendarFeed(ctx context.Context, owner string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	enc, err := exchange.NewEncoder(v2.Format_FORMAT_ICALENDAR, &buf)
+	if err != nil {
+		return nil, "", errs.Internal("渲染日历失败", err)
+	}
+	h := sha256.New()
+	err = s.eachToDoWhere(ctx, "`Owner`=?", []interface{}{owner}, func(td *v2.ToDo) error {
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(td)
+		if err != nil {
+			return errs.Internal("渲染日历失败", err)
+		}
+		h.Write(b)
+		return enc.Encode(td)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, "", errs.Internal("渲染日历失败", err)
+	}
+	return buf.Bytes(), `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+}
@@ -0,0 +1,125 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/auth"
+	"go-grpc/internal/pkg/errs"
+)
+
+// 加密description时绑定的附加数据，包含行的ID，密文不能挪到别的列或者别的行中解密
+func descriptionAAD(id int64) []byte {
+	return []byte("ToDo.Description:" + strconv.FormatInt(id, 10))
+}
+
+// 每批重新加密的行数
+const reencryptBatchSize = 100
+
+// 写入数据库之前加密id这一行的description，没有开启加密时保存明文
+func (s *ToDoServiceServer) sealDescription(id int64, description string) (string, error) {
+	sealed, err := s.keys.Seal(description, descriptionAAD(id))
+	if err != nil {
+		return "", errs.Internal("加密description失败", err)
+	}
+	return sealed, nil
+}
+
+// 从数据库读出之后解密description，明文保存的直接使用
+func (s *ToDoServiceServer) openDescription(td *v2.ToDo) error {
+	description, err := s.keys.Open(td.Description, descriptionAAD(td.Id))
+	if err != nil {
+		return errs.Internal("解密description失败", err)
+	}
+	td.Description = description
+	return nil
+}
+
+// 没有开启认证时调用方为空，这样创建的ToDo不属于任何人
+func ownerFromContext(ctx context.Context) string {
+	if id, ok := auth.FromContext(ctx); ok {
+		return id.Subject
+	}
+	return ""
+}
+
+// 插入一条ToDo，记录创建者，返回它的ID；description的密文和ID绑定，所以先插入其他字段，拿到ID之后再写入description
+func (s *ToDoServiceServer) insert(ctx context.Context, tx *sql.Tx, td *v2.ToDo, reminder time.Time) (int64, error) {
+	res, err := tx.ExecContext(ctx, "INSERT INTO ToDo(`Title`, `Description`, `Reminder`, `Owner`) VALUES(?, '', ?, ?)", td.Title, reminder, ownerFromContext(ctx))
+	if err != nil {
+		return 0, errs.Wrap("添加ToDo失败", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, errs.Wrap("获取最近ID失败", err)
+	}
+	description, err := s.sealDescription(id, td.Description)
+	if err != nil {
+		return 0, err
+	}
+	if len(description) > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE ToDo SET `Description`=? WHERE `ID`=?", description, id); err != nil {
+			return 0, errs.Wrap("添加ToDo失败", err)
+		}
+	}
+	return id, nil
+}
+
+// ReencryptDescriptions 把所有不是用当前主密钥加密的description重新加密，没有开启加密时还原成明文
+// 按ID分批处理，服务运行时也可以执行；期间被修改过的行已经是当前的形式，直接跳过
+// 返回检查的行数和重新写入的行数
+func (s *ToDoServiceServer) ReencryptDescriptions(ctx context.Context) (scanned, rewritten int64, err error) {
+	var after int64
+	for {
+		rows, err := s.db.QueryContext(ctx, "SELECT `ID`, `Description` FROM ToDo WHERE `ID`>? ORDER BY `ID` LIMIT ?", after, reencryptBatchSize)
+		if err != nil {
+			return scanned, rewritten, errs.Wrap("查询失败", err)
+		}
+		type row struct {
+			id          int64
+			description string
+		}
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.description); err != nil {
+				rows.Close()
+				return scanned, rewritten, errs.Wrap("查询失败", err)
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return scanned, rewritten, errs.Wrap("获取数据失败", err)
+		}
+		if len(batch) == 0 {
+			return scanned, rewritten, nil
+		}
+		for _, r := range batch {
+			scanned++
+			after = r.id
+			if s.keys.Current(r.description) {
+				continue
+			}
+			plaintext, err := s.keys.Open(r.description, descriptionAAD(r.id))
+			if err != nil {
+				return scanned, rewritten, errs.Internal("解密description失败", err)
+			}
+			sealed, err := s.sealDescription(r.id, plaintext)
+			if err != nil {
+				return scanned, rewritten, err
+			}
+			// 只在内容没有变化时写入，避免覆盖同时进行的修改
+			res, err := s.db.ExecContext(ctx, "UPDATE ToDo SET `Description`=? WHERE `ID`=? AND `Description`=?", sealed, r.id, r.description)
+			if err != nil {
+				return scanned, rewritten, errs.Wrap("更新失败", err)
+			}
+			if n, err := res.RowsAffected(); err == nil && n > 0 {
+				rewritten++
+			}
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package v2
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/audit"
+	"go-grpc/internal/pkg/errs"
+	"go-grpc/internal/pkg/exchange"
+	"go-grpc/internal/pkg/validate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 按ID顺序遍历所有ToDo，不会一次性把所有数据读进内存
+func (s *ToDoServiceServer) eachToDo(ctx context.Context, fn func(td *v2.ToDo) error) error {
+	return s.eachToDoWhere(ctx, "", nil, fn)
+}
+
+// 和eachToDo一样，只遍历满足where条件的ToDo，where为空时遍历所有ToDo
+func (s *ToDoServiceServer) eachToDoWhere(ctx context.Context, where string, args []interface{}, fn func(td *v2.ToDo) error) error {
+	query := "SELECT `ID`, `Title`, `Description`, `Reminder` FROM ToDo"
+	if len(where) > 0 {
+		query += " WHERE " + where
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY `ID`", args...)
+	if err != nil {
+		return errs.Wrap("查询失败", err)
+	}
+	defer rows.Close()
+	var reminder time.Time
+	for rows.Next() {
+		td := new(v2.ToDo)
+		if err := rows.Scan(&td.Id, &td.Title, &td.Description, &reminder); err != nil {
+			return errs.Wrap("查询失败", err)
+		}
+		td.Reminder, err = ptypes.TimestampProto(reminder)
+		if err != nil {
+			return errs.Internal("reminder 格式无效", err)
+		}
+		if err := s.openDescription(td); err != nil {
+			return err
+		}
+		if err := fn(td); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return errs.Wrap("获取数据失败", err)
+	}
+	return nil
+}
+
+// ExportTo 把所有ToDo按指定格式写到w，gRPC的流式导出和HTTP的文件下载都走这里
+func (s *ToDoServiceServer) ExportTo(ctx context.Context, format v2.Format, w io.Writer) error {
+	enc, err := exchange.NewEncoder(format, w)
+	if err != nil {
+		return errs.InvalidArgument(errs.ReasonInvalidArgument, err.Error(), "field", "format")
+	}
+	if err := s.eachToDo(ctx, enc.Encode); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+const (
+	// 一次导入最多的行数，超过时整个文件都不导入
+	maxImportRows = 10000
+	// 返回的错误行最多这么多，再多一般是选错了格式，直接拒绝
+	maxImportErrors = 100
+	// 导入文件最大的字节数，CSV的一个字段可以任意长，只限制行数不够
+	maxImportSize = 32 << 20
+)
+
+// 读到超过max个字节时返回错误，而不是像io.LimitReader那样假装文件结束了，导入一半的文件
+type importSizeReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *importSizeReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > maxImportSize {
+		return 0, errs.InvalidArgument(errs.ReasonInvalidArgument, fmt.Sprintf("导入文件超过了%d字节的限制", maxImportSize), "limit", strconv.Itoa(maxImportSize))
+	}
+	return n, err
+}
+
+// 导入的ToDo要满足和Create一样的约束
+func validateImported(td *v2.ToDo) error {
+	violations := validate.Violations(td)
+	if len(violations) == 0 {
+		return nil
+	}
+	descs := make([]string, 0, len(violations))
+	for _, v := range violations {
+		descs = append(descs, v.Field+v.Description)
+	}
+	return fmt.Errorf("%s", strings.Join(descs, "；"))
+}
+
+// ImportFrom 从r中按指定格式读取ToDo并新增，文件中的id会被忽略
+// 格式有问题的行会记录在返回的errors里，其余的行在同一个事务中写入；dryRun时只做校验
+func (s *ToDoServiceServer) ImportFrom(ctx context.Context, format v2.Format, dryRun bool, r io.Reader) (*v2.ImportResponse, error) {
+	dec, err := exchange.NewDecoder(format, &importSizeReader{r: r})
+	if err != nil {
+		return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, err.Error(), "field", "format")
+	}
+	res := &v2.ImportResponse{DryRun: dryRun}
+	var valid []*v2.ToDo
+	// 所有行都在内存中攒到一个事务里写入，所以行数和错误数都要有上限
+	rowError := func(row int64, msg string) error {
+		if len(res.Errors) >= maxImportErrors {
+			return errs.InvalidArgument(errs.ReasonInvalidArgument, fmt.Sprintf("导入文件中有超过%d行错误，请检查文件的格式", maxImportErrors))
+		}
+		res.Errors = append(res.Errors, &v2.ImportRowError{Row: row, Message: msg})
+		return nil
+	}
+	for {
+		td, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		rowErr, isRowErr := err.(*exchange.RowError)
+		if err != nil && !isRowErr {
+			// 读取请求流失败时已经是gRPC错误，其余的是文件本身的格式问题
+			if _, ok := status.FromError(err); !ok {
+				return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, "解析导入文件失败："+err.Error())
+			}
+			return nil, err
+		}
+		res.Total++
+		if res.Total > maxImportRows {
+			return nil, errs.New(codes.InvalidArgument, errs.ReasonImportTooLarge, fmt.Sprintf("导入文件超过了%d行的限制", maxImportRows), "limit", strconv.Itoa(maxImportRows))
+		}
+		if isRowErr {
+			if err := rowError(rowErr.Row, rowErr.Err.Error()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := validateImported(td); err != nil {
+			if err := rowError(dec.Row(), err.Error()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		valid = append(valid, td)
+	}
+	if dryRun || len(valid) == 0 {
+		return res, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap("开启事务失败", err)
+	}
+	for _, td := range valid {
+		reminder, _ := ptypes.Timestamp(td.Reminder)
+		if td.Id, err = s.insert(ctx, tx, td, reminder); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errs.Wrap("提交事务失败", err)
+	}
+	for _, td := range valid {
+		audit.Record(ctx, toDoResource(td.Id), "", audit.Hash(td))
+		s.search.Index(td)
+	}
+	res.Imported = int64(len(valid))
+	return res, nil
+}
+
+func (s *ToDoServiceServer) Export(req *v2.ExportRequest, stream v2.ToDoService_ExportServer) error {
+	// 攒够一块再发送，避免每一行都是一条消息
+	w := bufio.NewWriterSize(&chunkWriter{send: func(p []byte) error {
+		return stream.Send(&v2.ExportResponse{Chunk: p})
+	}}, downloadChunkSize)
+	if err := s.ExportTo(stream.Context(), req.Format, w); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func (s *ToDoServiceServer) Import(stream v2.ToDoService_ImportServer) error {
+	// 第一条消息必须是导入的选项
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return errs.InvalidArgument(errs.ReasonInvalidArgument, "第一条消息必须是导入选项")
+	}
+	if err != nil {
+		return errs.Wrap("读取导入选项失败", err)
+	}
+	opts := req.GetOptions()
+	if opts == nil {
+		return errs.InvalidArgument(errs.ReasonInvalidArgument, "第一条消息必须是导入选项")
+	}
+	r := &chunkReader{next: func() ([]byte, error) {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if req.GetOptions() != nil {
+			return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, "导入选项只能出现在第一条消息")
+		}
+		return req.GetChunk(), nil
+	}}
+	res, err := s.ImportFrom(stream.Context(), opts.Format, opts.DryRun, r)
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(res)
+}
@@ -0,0 +1,69 @@
+package v2
+
+import (
+	"time"
+
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/validate"
+)
+
+const (
+	// 和schema.sql中的列长度保持一致
+	MaxTitleLength = 200
+	// description加密之后会变长，列是TEXT，这里限制的是明文的长度
+	MaxDescriptionLength = 1024
+	MaxAttachmentName    = 255
+	MaxQueryLength       = 200
+	MaxAPIKeyName        = 200
+)
+
+var (
+	// Reminder列是MySQL的TIMESTAMP类型，只能保存这个范围内的时间
+	MinReminder = time.Date(1970, 1, 1, 0, 0, 1, 0, time.UTC)
+	MaxReminder = time.Date(2038, 1, 19, 3, 14, 7, 0, time.UTC)
+)
+
+// ToDoRules 返回ToDo本身的校验规则，prefix是ToDo在请求中的路径，例如"toDo."
+// v1的请求也复用这一套规则
+func ToDoRules(prefix string) []validate.Rule {
+	return []validate.Rule{
+		validate.NotBlank(prefix + "title"),
+		validate.MaxLength(prefix+"title", MaxTitleLength),
+		validate.MaxLength(prefix+"description", MaxDescriptionLength),
+		validate.Required(prefix + "reminder"),
+		validate.TimeBetween(prefix+"reminder", MinReminder, MaxReminder),
+	}
+}
+
+func init() {
+	validate.Register(&v2.ToDo{}, ToDoRules("")...)
+	validate.Register(&v2.CreateRequest{}, append([]validate.Rule{validate.Required("toDo")}, ToDoRules("toDo.")...)...)
+	validate.Register(&v2.ReadRequest{}, validate.Positive("id"))
+	// 部分更新时title可以不传，合并之后的ToDo在Update中再按ToDo的规则校验一次
+	validate.Register(&v2.UpdateRequest{},
+		validate.Required("toDo"),
+		validate.Positive("toDo.id"),
+		validate.MaxLength("toDo.title", MaxTitleLength),
+		validate.MaxLength("toDo.description", MaxDescriptionLength),
+		validate.TimeBetween("toDo.reminder", MinReminder, MaxReminder),
+		validate.FieldMaskPaths("updateMask", "title", "description", "reminder"),
+	)
+	validate.Register(&v2.DeleteRequest{}, validate.Positive("id"))
+	validate.Register(&v2.ListRequest{}, validate.Range("pageSize", 0, maxListPageSize))
+	validate.Register(&v2.SearchRequest{},
+		validate.NotBlank("q"),
+		validate.MaxLength("q", MaxQueryLength),
+		validate.Range("pageSize", 0, MaxSearchPageSize),
+	)
+	validate.Register(&v2.UploadAttachmentRequest{},
+		validate.Positive("info.toDoId"),
+		validate.NotBlank("info.name"),
+		validate.MaxLength("info.name", MaxAttachmentName),
+	)
+	validate.Register(&v2.DownloadAttachmentRequest{}, validate.Positive("id"))
+	validate.Register(&v2.ExportRequest{}, validate.DefinedEnum("format"))
+	validate.Register(&v2.ImportRequest{}, validate.DefinedEnum("options.format"))
+	validate.Register(&v2.CreateAPIKeyRequest{}, validate.NotBlank("name"), validate.MaxLength("name", MaxAPIKeyName))
+	validate.Register(&v2.RotateAPIKeyRequest{}, validate.Positive("id"))
+	validate.Register(&v2.RevokeAPIKeyRequest{}, validate.Positive("id"))
+}
@@ -0,0 +1,75 @@
+package v2
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/errs"
+	"go-grpc/internal/pkg/search"
+)
+
+const (
+	defaultSearchPageSize = 20
+	MaxSearchPageSize     = 100
+)
+
+func (s *ToDoServiceServer) Search(ctx context.Context, req *v2.SearchRequest) (*v2.SearchResponse, error) {
+	q := strings.TrimSpace(req.Q)
+	if len(q) == 0 {
+		return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, "搜索关键字不能为空", "field", "q")
+	}
+	size := int(req.PageSize)
+	if size <= 0 {
+		size = defaultSearchPageSize
+	}
+	if size > MaxSearchPageSize {
+		size = MaxSearchPageSize
+	}
+	// pageToken就是下一页的偏移量
+	offset := 0
+	if len(req.PageToken) > 0 {
+		var err error
+		offset, err = strconv.Atoi(req.PageToken)
+		if err != nil || offset < 0 {
+			return nil, errs.InvalidArgument(errs.ReasonInvalidPageToken, "pageToken无效")
+		}
+	}
+	hits, total, err := s.search.Search(ctx, q, offset, size)
+	if err != nil {
+		return nil, errs.Wrap("搜索失败", err)
+	}
+	terms := search.Tokenize(q)
+	results := make([]*v2.SearchResult, 0, len(hits))
+	for _, h := range hits {
+		// MySQL的搜索结果直接从表中读出，description可能是加密的；内存索引中本来就是明文，不能再解一次
+		if h.Stored {
+			if err := s.openDescription(h.ToDo); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, &v2.SearchResult{
+			ToDo:               h.ToDo,
+			Score:              h.Score,
+			TitleSnippet:       search.Highlight(h.ToDo.Title, terms),
+			DescriptionSnippet: search.Highlight(h.ToDo.Description, terms),
+		})
+	}
+	res := &v2.SearchResponse{
+		Results:   results,
+		TotalSize: int64(total),
+	}
+	if next := offset + len(hits); len(hits) > 0 && next < total {
+		res.NextPageToken = strconv.Itoa(next)
+	}
+	return res, nil
+}
+
+// RebuildSearchIndex 把数据库中所有的ToDo重新写入搜索索引，进程内索引启动时需要调用一次
+func (s *ToDoServiceServer) RebuildSearchIndex(ctx context.Context) error {
+	return s.eachToDo(ctx, func(td *v2.ToDo) error {
+		s.search.Index(td)
+		return nil
+	})
+}
@@ -0,0 +1,35 @@
+package v2
+
+// 把客户端流里的chunk拼成一个io.Reader，这样就能直接交给BlobStore.Put或者Decoder
+type chunkReader struct {
+	next func() ([]byte, error)
+	buf  []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.next()
+		if err != nil {
+			return 0, err
+		}
+		r.buf = chunk
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// 把写入的数据作为一个chunk发到服务端流里
+type chunkWriter struct {
+	send func(p []byte) error
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	// gRPC发送时会序列化，但是调用方可能复用p，所以这里拷贝一份
+	chunk := make([]byte, len(p))
+	copy(chunk, p)
+	if err := w.send(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,282 @@
+package v2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/golang/protobuf/ptypes"
+	v2 "go-grpc/api/server/v2"
+	"go-grpc/internal/pkg/audit"
+	"go-grpc/internal/pkg/blob"
+	"go-grpc/internal/pkg/envelope"
+	"go-grpc/internal/pkg/errs"
+	"go-grpc/internal/pkg/search"
+	"go-grpc/internal/pkg/validate"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultListPageSize = 100
+	maxListPageSize     = 1000
+)
+
+// ToDoServiceServer 是ToDo服务的实现，v1的接口也适配到这里
+type ToDoServiceServer struct {
+	v2.UnimplementedToDoServiceServer
+	db *sql.DB
+	// 附件的内容存放在blobs里，数据库只保存元数据
+	blobs blob.BlobStore
+	// 全文搜索引擎，ToDo的增删改都要同步给它
+	search search.Engine
+	// description在数据库中加密保存，读写时在这里加解密，对上层是透明的
+	keys *envelope.Keyring
+}
+
+func NewToDoServiceServer(db *sql.DB, blobs blob.BlobStore, engine search.Engine, keys *envelope.Keyring) *ToDoServiceServer {
+	return &ToDoServiceServer{db: db, blobs: blobs, search: engine, keys: keys}
+}
+
+// 审计日志中ToDo的资源名，和REST的路径一致
+func toDoResource(id int64) string {
+	return "todos/" + strconv.FormatInt(id, 10)
+}
+
+func (s *ToDoServiceServer) connect(ctx context.Context) (*sql.Conn, error) {
+	c, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, errs.Wrap("连接数据库失败", err)
+	}
+	return c, nil
+}
+
+func (s *ToDoServiceServer) Create(ctx context.Context, req *v2.CreateRequest) (*v2.CreateResponse, error) {
+	// 拦截器链可以去掉validate，HTTP的接口也不经过拦截器，这里不依赖拦截器，自己再校验一次
+	if err := validate.Validate(req); err != nil {
+		return nil, err
+	}
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	reminder, err := ptypes.Timestamp(req.ToDo.Reminder)
+	if err != nil {
+		return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, "reminder参数无效", "field", "toDo.reminder")
+	}
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap("开启事务失败", err)
+	}
+	defer tx.Rollback()
+	id, err := s.insert(ctx, tx, req.ToDo, reminder)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errs.Wrap("提交事务失败", err)
+	}
+	td := &v2.ToDo{Id: id, Title: req.ToDo.Title, Description: req.ToDo.Description, Reminder: req.ToDo.Reminder}
+	audit.Record(ctx, toDoResource(id), "", audit.Hash(td))
+	s.search.Index(td)
+	return &v2.CreateResponse{ToDo: td}, nil
+}
+
+// 在查询上执行一次读取，Read、Update和Delete共用
+func (s *ToDoServiceServer) read(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}, id int64, forUpdate bool) (*v2.ToDo, error) {
+	query := "SELECT  `ID`, `Title`, `Description`, `Reminder` FROM ToDo WHERE `ID`=?"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	rows, err := q.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, errs.Wrap("查找数据失败", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, errs.Wrap("获取数据失败", err)
+		}
+		return nil, errs.NotFound(errs.ReasonToDoNotFound, fmt.Sprintf("ID='%d'找不到", id), "id", strconv.FormatInt(id, 10))
+	}
+
+	var td v2.ToDo
+	var reminder time.Time
+	if err := rows.Scan(&td.Id, &td.Title, &td.Description, &reminder); err != nil {
+		return nil, errs.Wrap("查找数据失败", err)
+	}
+	td.Reminder, err = ptypes.TimestampProto(reminder)
+	if err != nil {
+		return nil, errs.Internal("reminder 格式无效", err)
+	}
+	if err := s.openDescription(&td); err != nil {
+		return nil, err
+	}
+
+	if rows.Next() {
+		return nil, errs.Internal("查找数据失败", fmt.Errorf("查到多条数据ID：%d", id))
+	}
+	return &td, nil
+}
+
+func (s *ToDoServiceServer) Read(ctx context.Context, req *v2.ReadRequest) (*v2.ReadResponse, error) {
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	td, err := s.read(ctx, c, req.Id, false)
+	if err != nil {
+		return nil, err
+	}
+	return &v2.ReadResponse{ToDo: td}, nil
+}
+
+// Update 在一个事务中先读出原来的ToDo，按updateMask合并之后再写回去，updateMask为空时整体替换
+func (s *ToDoServiceServer) Update(ctx context.Context, req *v2.UpdateRequest) (*v2.UpdateResponse, error) {
+	// 和Create一样不依赖拦截器，toDo为空时在这里返回InvalidArgument
+	if err := validate.Validate(req); err != nil {
+		return nil, err
+	}
+	paths := []string{"title", "description", "reminder"}
+	if req.UpdateMask != nil && len(req.UpdateMask.Paths) > 0 {
+		paths = req.UpdateMask.Paths
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap("开启事务失败", err)
+	}
+	defer tx.Rollback()
+	td, err := s.read(ctx, tx, req.ToDo.Id, true)
+	if err != nil {
+		return nil, err
+	}
+	before := audit.Hash(td)
+	for _, p := range paths {
+		switch p {
+		case "title":
+			td.Title = req.ToDo.Title
+		case "description":
+			td.Description = req.ToDo.Description
+		case "reminder":
+			td.Reminder = req.ToDo.Reminder
+		default:
+			return nil, errs.InvalidArgument(errs.ReasonUnsupportedField, fmt.Sprintf("不支持更新的字段：%s", p), "field", p)
+		}
+	}
+	// 部分更新时请求中的ToDo不一定完整，合并之后再校验一次
+	if err := validate.Validate(td); err != nil {
+		return nil, err
+	}
+	reminder, err := ptypes.Timestamp(td.Reminder)
+	if err != nil {
+		return nil, errs.InvalidArgument(errs.ReasonInvalidArgument, "reminder参数无效", "field", "toDo.reminder")
+	}
+	description, err := s.sealDescription(td.Id, td.Description)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE ToDo SET `Title`=?, `Description`=?, `Reminder`=? WHERE `ID`=?", td.Title, description, reminder, td.Id)
+	if err != nil {
+		return nil, errs.Wrap("更新失败", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errs.Wrap("提交事务失败", err)
+	}
+	audit.Record(ctx, toDoResource(td.Id), before, audit.Hash(td))
+	s.search.Index(td)
+	return &v2.UpdateResponse{ToDo: td}, nil
+}
+
+// Delete 在事务中先读出要删除的ToDo，连同它的附件一起删除，审计日志中记录删除前的内容
+func (s *ToDoServiceServer) Delete(ctx context.Context, req *v2.DeleteRequest) (*v2.DeleteResponse, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errs.Wrap("开启事务失败", err)
+	}
+	defer tx.Rollback()
+	td, err := s.read(ctx, tx, req.Id, true)
+	if err != nil {
+		return nil, err
+	}
+	attachments, blobKeys, err := s.deleteAttachments(ctx, tx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM ToDo WHERE `ID`=?", req.Id); err != nil {
+		return nil, errs.Wrap("删除失败", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, errs.Wrap("提交事务失败", err)
+	}
+	s.removeBlobs(ctx, blobKeys)
+	for _, a := range attachments {
+		audit.Record(ctx, attachmentResource(a.Id), audit.Hash(a), "")
+	}
+	audit.Record(ctx, toDoResource(req.Id), audit.Hash(td), "")
+	s.search.Remove(req.Id)
+	return &v2.DeleteResponse{}, nil
+}
+
+// Stats 返回ToDo的总数和reminder还没到的数量，用于监控指标
+func (s *ToDoServiceServer) Stats(ctx context.Context) (total, pending int64, err error) {
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(`Reminder` > ?), 0) FROM ToDo", time.Now().In(time.UTC)).Scan(&total, &pending)
+	return total, pending, err
+}
+
+// List 按ID分页，pageToken是上一页最后一个ID
+func (s *ToDoServiceServer) List(ctx context.Context, req *v2.ListRequest) (*v2.ListResponse, error) {
+	size := int(req.PageSize)
+	if size <= 0 {
+		size = defaultListPageSize
+	}
+	if size > maxListPageSize {
+		size = maxListPageSize
+	}
+	var after int64
+	if len(req.PageToken) > 0 {
+		var err error
+		after, err = strconv.ParseInt(req.PageToken, 10, 64)
+		if err != nil {
+			return nil, errs.InvalidArgument(errs.ReasonInvalidPageToken, "pageToken无效")
+		}
+	}
+	c, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	// 多查一条，用来判断是否还有下一页
+	rows, err := c.QueryContext(ctx, "SELECT `ID`, `Title`, `Description`, `Reminder` FROM ToDo WHERE `ID`>? ORDER BY `ID` LIMIT ?", after, size+1)
+	if err != nil {
+		return nil, errs.Wrap("查询失败", err)
+	}
+	defer rows.Close()
+	var reminder time.Time
+	list := make([]*v2.ToDo, 0)
+	for rows.Next() {
+		td := new(v2.ToDo)
+		if err := rows.Scan(&td.Id, &td.Title, &td.Description, &reminder); err != nil {
+			return nil, errs.Wrap("查询失败", err)
+		}
+		td.Reminder, err = ptypes.TimestampProto(reminder)
+		if err != nil {
+			return nil, errs.Internal("reminder 格式无效", err)
+		}
+		if err := s.openDescription(td); err != nil {
+			return nil, err
+		}
+		list = append(list, td)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errs.Wrap("获取数据失败", err)
+	}
+	res := &v2.ListResponse{ToDos: list}
+	if len(list) > size {
+		res.ToDos = list[:size]
+		res.NextPageToken = strconv.FormatInt(list[size-1].Id, 10)
+	}
+	return res, nil
+}